@@ -1,19 +1,20 @@
 // Package main provides the entry point for the load balancer application.
-// It initializes and coordinates the load balancer, backend servers, health checks,
+// It initializes and coordinates the load balancer, backend discovery, health checks,
 // and HTTP server with rate limiting middleware.
 package main
 
 import (
 	"context"
-	"load-balancer/internal/backend"
 	"load-balancer/internal/balancer"
 	"load-balancer/internal/config"
+	"load-balancer/internal/discovery"
 	"load-balancer/internal/health"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
 	"load-balancer/internal/server"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
@@ -27,41 +28,87 @@ func main() {
 	}
 	log.Info().
 		Str("listen_address", cfg.ListenAddress).
+		Str("admin_listen_address", cfg.AdminListenAddress).
+		Int("tls_certificates", len(cfg.Certificates())).
+		Bool("http2", cfg.ServerHTTP2).
+		Bool("h2c", cfg.ServerH2C).
+		Str("backend_protocol", cfg.BackendProtocol).
 		Strs("backends", cfg.Backends).
+		Str("config_file", cfg.ConfigFile).
+		Int("pools", len(cfg.File.Pools)+1).
+		Int("routes", len(cfg.File.Routes)).
+		Str("discovery_file", cfg.DiscoveryFile).
+		Str("discovery_dns_name", cfg.DiscoveryDNSName).
 		Float64("rate_limit_capacity", cfg.RateLimitCapacity).
 		Float64("rate_limit_refill_rate", cfg.RateLimitRefillRate).
+		Bool("rate_limit_per_ip", cfg.RateLimitPerIP).
+		Str("balance_strategy", cfg.BalanceStrategy).
+		Strs("trusted_proxies", cfg.TrustedProxies).
 		Msg("Loaded configuration")
 
-	var backends []*backend.Backend
-	for _, addr := range cfg.Backends {
-		backends = append(backends, &backend.Backend{
-			Addr: addr,
-		})
-	}
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
-	backendsReady := make(chan struct{})
-	backend.StartBackend(ctx, backends, &wg, backendsReady)
-
-	// Wait for all backends to be ready
-	<-backendsReady
-	log.Info().Msg("All backends are ready")
+	routes, err := router.New(cfg.File.Routes)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build routing table")
+	}
+	pools := pool.NewRegistry()
+	srv := server.NewServer(cfg, pools, routes)
 
-	strategy := balancer.NewRoundRobinStrategy(backends)
-	lb := balancer.NewBalancer(strategy, backends)
+	// Backends of every pool are registered by its discovery reconciler
+	var reconcilers []*discovery.Reconciler
+	for _, pc := range cfg.Pools() {
+		p := pool.New(ctx, pool.Config{
+			Name:     pc.Name,
+			Strategy: newStrategy(pc.Strategy),
+			Probe: health.Probe{
+				Path:                pc.Health.Path,
+				DegradedLatency:     pc.Health.DegradedLatency,
+				DegradedStatusCodes: pc.Health.DegradedStatusCodes,
+				DegradedBody:        pc.Health.DegradedBody,
+			},
+			HealthInterval: pc.Health.Interval,
+			Defaults: discovery.BackendDefaults{
+				Breaker:   cfg.BreakerSettings(),
+				TLS:       pc.Transport.TLS,
+				MaxConns:  pc.Transport.MaxConns,
+				Transport: pc.Transport.TransportOptions,
+			},
+			RequestTimeout: pc.RequestTimeout,
+		})
+		if err := pools.Add(p); err != nil {
+			log.Fatal().Err(err).Str("pool", pc.Name).Msg("Failed to register pool")
+		}
 
-	health.StartHealthCheck(ctx, backends, 15*time.Second)
+		providers, err := newProviders(pc)
+		if err != nil {
+			log.Fatal().Err(err).Str("pool", pc.Name).Msg("Failed to configure backend discovery")
+		}
+		reconciler := discovery.NewReconciler(srv.PoolBackends(p), p.Defaults)
+		go func() {
+			if err := reconciler.Run(ctx, discovery.Compose(providers...)); err != nil {
+				log.Error().Err(err).Str("pool", pc.Name).Msg("Backend discovery stopped")
+			}
+		}()
+		reconcilers = append(reconcilers, reconciler)
+	}
+	for _, reconciler := range reconcilers {
+		<-reconciler.Synced()
+	}
 
-	srv := server.NewServer(cfg, lb)
 	go func() {
 		err := srv.Start()
 		if err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("Server failed")
 		}
 	}()
+	go func() {
+		err := srv.StartAdmin()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Admin server failed")
+		}
+	}()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -76,7 +123,38 @@ func main() {
 		log.Error().Err(err).Msg("Server shutdown failed")
 	}
 
-	wg.Wait()
+	log.Info().Msg("Server stopped")
+}
+
+// newStrategy creates the balancing strategy with the given name.
+func newStrategy(name string) balancer.Strategy {
+	switch name {
+	case config.StrategyWeightedRoundRobin:
+		return balancer.NewWeightedRoundRobinStrategy(nil)
+	default:
+		return balancer.NewRoundRobinStrategy(nil)
+	}
+}
+
+// newProviders creates the discovery providers of a pool: its static
+// backends, then its discovery file and DNS name if configured.
+func newProviders(pc config.PoolConfig) ([]discovery.Discovery, error) {
+	providers := []discovery.Discovery{discovery.NewStaticProvider(pc.Backends)}
+
+	if pc.DiscoveryFile != "" {
+		provider := discovery.NewFileProvider(pc.DiscoveryFile)
+		if _, err := provider.Load(); err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
 
-	log.Info().Msg("Server and backends stopped")
+	if pc.DiscoveryDNS != nil {
+		provider, err := discovery.NewDNSProvider(*pc.DiscoveryDNS)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
 }
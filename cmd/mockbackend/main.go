@@ -0,0 +1,103 @@
+// Package main provides a mock backend server for developing and testing
+// the load balancer. It starts one HTTP server per address with
+// configurable latency, error injection, response size and a health
+// endpoint that can be toggled at runtime.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	addrs := flag.String("addrs", "localhost:9001,localhost:9002", "Comma-separated listen addresses, one backend per address")
+	latency := flag.String("latency", "", "Latency distribution: 50ms, uniform:10ms-100ms, normal:50ms:10ms or exp:50ms")
+	errorRate := flag.Float64("error-rate", 0, "Share of requests answered with an error (0-1)")
+	errorStatus := statusCode(http.StatusInternalServerError)
+	flag.Var(&errorStatus, "error-status", "Status code for injected errors (400-599)")
+	responseSize := flag.Int("response-size", 0, "Response body size in bytes (0 echoes the backend address)")
+	flag.Parse()
+
+	latencyFn, err := parseLatency(*latency)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid latency")
+	}
+	if *errorRate < 0 || *errorRate > 1 {
+		log.Fatal().Float64("error_rate", *errorRate).Msg("Error rate must be between 0 and 1")
+	}
+	if *responseSize < 0 {
+		log.Fatal().Int("response_size", *responseSize).Msg("Response size cannot be negative")
+	}
+	opts := Options{
+		Latency:      latencyFn,
+		ErrorRate:    *errorRate,
+		ErrorStatus:  int(errorStatus),
+		ResponseSize: *responseSize,
+	}
+
+	var backends []*MockBackend
+	var wg sync.WaitGroup
+	for _, addr := range strings.Split(*addrs, ",") {
+		m := NewMockBackend(strings.TrimSpace(addr), opts)
+		listener, err := m.Listen()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to start mock backend")
+		}
+		backends = append(backends, m)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Str("address", m.Addr()).Msg("Mock backend failed")
+			}
+		}()
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+	log.Info().Msg("Received shutdown signal, stopping mock backends")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, m := range backends {
+		if err := m.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Str("address", m.Addr()).Msg("Mock backend shutdown failed")
+		}
+	}
+	wg.Wait()
+
+	log.Info().Msg("Mock backends stopped")
+}
+
+// statusCode is a flag holding an HTTP status code.
+type statusCode int
+
+func (s *statusCode) String() string {
+	return strconv.Itoa(int(*s))
+}
+
+// Set parses the flag value, accepting only error codes from 400 to 599.
+func (s *statusCode) Set(value string) error {
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid status code %q", value)
+	}
+	if code < 400 || code > 599 {
+		return fmt.Errorf("status code %d is out of range 400-599", code)
+	}
+	*s = statusCode(code)
+	return nil
+}
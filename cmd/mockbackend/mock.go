@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Health states reported by a mock backend's /health endpoint.
+const (
+	healthHealthy   = "healthy"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// Latency returns a delay to apply before answering a request.
+type Latency func() time.Duration
+
+// parseLatency parses a latency distribution:
+//
+//	""                   no delay
+//	"50ms"               fixed delay
+//	"uniform:10ms-100ms" uniformly distributed between two bounds
+//	"normal:50ms:10ms"   normally distributed with mean and standard deviation
+//	"exp:50ms"           exponentially distributed with the given mean
+func parseLatency(spec string) (Latency, error) {
+	if spec == "" || spec == "0" {
+		return func() time.Duration { return 0 }, nil
+	}
+
+	kind, args, found := strings.Cut(spec, ":")
+	if !found {
+		d, err := time.ParseDuration(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed latency %q: %w", spec, err)
+		}
+		return func() time.Duration { return d }, nil
+	}
+
+	switch kind {
+	case "uniform":
+		lowRaw, highRaw, ok := strings.Cut(args, "-")
+		if !ok {
+			return nil, fmt.Errorf("uniform latency must be min-max, got %q", args)
+		}
+		low, err := time.ParseDuration(lowRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uniform latency minimum: %w", err)
+		}
+		high, err := time.ParseDuration(highRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uniform latency maximum: %w", err)
+		}
+		if high < low {
+			return nil, errors.New("uniform latency maximum is below minimum")
+		}
+		return func() time.Duration {
+			return low + time.Duration(rand.Int64N(int64(high-low)+1))
+		}, nil
+	case "normal":
+		meanRaw, stddevRaw, ok := strings.Cut(args, ":")
+		if !ok {
+			return nil, fmt.Errorf("normal latency must be mean:stddev, got %q", args)
+		}
+		mean, err := time.ParseDuration(meanRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid normal latency mean: %w", err)
+		}
+		stddev, err := time.ParseDuration(stddevRaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid normal latency deviation: %w", err)
+		}
+		return func() time.Duration {
+			return max(0, time.Duration(rand.NormFloat64()*float64(stddev))+mean)
+		}, nil
+	case "exp":
+		mean, err := time.ParseDuration(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponential latency mean: %w", err)
+		}
+		return func() time.Duration {
+			return time.Duration(math.Min(rand.ExpFloat64()*float64(mean), math.MaxInt64))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown latency distribution %q", kind)
+	}
+}
+
+// Options controls the behaviour of mock backends.
+type Options struct {
+	Latency      Latency // Delay applied to each request
+	ErrorRate    float64 // Share of requests answered with ErrorStatus
+	ErrorStatus  int     // Status code for injected errors
+	ResponseSize int     // Size of the response body in bytes (0 echoes the backend address)
+}
+
+// MockBackend is a configurable HTTP server for exercising the load balancer.
+type MockBackend struct {
+	addr   string
+	opts   Options
+	health atomic.Value // Current health state reported by /health
+	srv    *http.Server
+}
+
+// NewMockBackend creates a healthy mock backend listening on addr.
+func NewMockBackend(addr string, opts Options) *MockBackend {
+	m := &MockBackend{addr: addr, opts: opts}
+	m.health.Store(healthHealthy)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", m.handleRequest)
+	mux.HandleFunc("/health", m.handleHealth)
+	m.srv = &http.Server{Handler: mux}
+	return m
+}
+
+// Addr returns the address the backend listens on.
+func (m *MockBackend) Addr() string {
+	return m.addr
+}
+
+// Listen binds the backend's address. With port 0 a random port is chosen
+// and Addr is updated accordingly.
+func (m *MockBackend) Listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", m.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", m.addr, err)
+	}
+	m.addr = listener.Addr().String()
+	return listener, nil
+}
+
+// Serve handles requests on the listener until Shutdown is called.
+func (m *MockBackend) Serve(listener net.Listener) error {
+	log.Info().Str("address", m.addr).Msg("Starting mock backend")
+	return m.srv.Serve(listener)
+}
+
+// Shutdown gracefully stops the backend.
+func (m *MockBackend) Shutdown(ctx context.Context) error {
+	return m.srv.Shutdown(ctx)
+}
+
+func (m *MockBackend) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if delay := m.opts.Latency(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("X-Backend", m.addr)
+	if m.opts.ErrorRate > 0 && rand.Float64() < m.opts.ErrorRate {
+		http.Error(w, "Injected error", m.opts.ErrorStatus)
+		return
+	}
+
+	if m.opts.ResponseSize > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(m.opts.ResponseSize))
+		w.Write(bytes.Repeat([]byte("x"), m.opts.ResponseSize))
+		return
+	}
+	fmt.Fprintf(w, "Response from backend %s", m.addr)
+}
+
+// handleHealth reports the current health state on GET and changes it on
+// POST /health?state=healthy|degraded|unhealthy.
+func (m *MockBackend) handleHealth(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		switch m.health.Load().(string) {
+		case healthDegraded:
+			w.Write([]byte(`{"status":"degraded"}`))
+		case healthUnhealthy:
+			http.Error(w, `{"status":"unhealthy"}`, http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"status":"ok"}`))
+		}
+	case http.MethodPost:
+		state := r.URL.Query().Get("state")
+		switch state {
+		case healthHealthy, healthDegraded, healthUnhealthy:
+		default:
+			http.Error(w, "state must be healthy, degraded or unhealthy", http.StatusBadRequest)
+			return
+		}
+		m.health.Store(state)
+		log.Info().Str("address", m.addr).Str("state", state).Msg("Mock backend health changed")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func startMockBackend(t *testing.T, opts Options) *MockBackend {
+	t.Helper()
+	if opts.Latency == nil {
+		opts.Latency, _ = parseLatency("")
+	}
+	m := NewMockBackend("127.0.0.1:0", opts) // listen on random available port
+	listener, err := m.Listen()
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go m.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	})
+	return m
+}
+
+func TestMockBackend_Serve(t *testing.T) {
+	m := startMockBackend(t, Options{})
+
+	resp, err := http.Get("http://" + m.Addr() + "/")
+	if err != nil {
+		t.Fatalf("Failed to connect to backend: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-Backend") != m.Addr() {
+		t.Errorf("Expected X-Backend %s, got %s", m.Addr(), resp.Header.Get("X-Backend"))
+	}
+}
+
+func TestMockBackend_ErrorsAndResponseSize(t *testing.T) {
+	failing := startMockBackend(t, Options{ErrorRate: 1, ErrorStatus: http.StatusBadGateway})
+	resp, err := http.Get("http://" + failing.Addr() + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected injected 502, got %d", resp.StatusCode)
+	}
+
+	sized := startMockBackend(t, Options{ResponseSize: 1024})
+	resp, err = http.Get("http://" + sized.Addr() + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ContentLength != 1024 {
+		t.Errorf("Expected 1024 byte response, got %d", resp.ContentLength)
+	}
+}
+
+func TestMockBackend_HealthToggle(t *testing.T) {
+	m := startMockBackend(t, Options{})
+	healthURL := "http://" + m.Addr() + "/health"
+
+	resp, err := http.Post(healthURL+"?state=unhealthy", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to toggle health: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(healthURL)
+	if err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 from unhealthy backend, got %d", resp.StatusCode)
+	}
+}
+
+func TestParseLatency(t *testing.T) {
+	tests := []struct {
+		spec     string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"50ms", 50 * time.Millisecond, 50 * time.Millisecond},
+		{"uniform:10ms-20ms", 10 * time.Millisecond, 20 * time.Millisecond},
+		{"normal:50ms:0s", 50 * time.Millisecond, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		latency, err := parseLatency(tt.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		for range 20 {
+			if d := latency(); d < tt.min || d > tt.max {
+				t.Errorf("%q: latency %s outside [%s, %s]", tt.spec, d, tt.min, tt.max)
+			}
+		}
+	}
+
+	for _, spec := range []string{"fast", "uniform:20ms-10ms", "pareto:1ms"} {
+		if _, err := parseLatency(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestStatusCodeFlag(t *testing.T) {
+	for _, value := range []string{"400", "503", "599"} {
+		var code statusCode
+		if err := code.Set(value); err != nil || code.String() != value {
+			t.Errorf("%q: expected the code to be accepted, got %v (%v)", value, code, err)
+		}
+	}
+	for _, value := range []string{"100", "200", "399", "600", "-500", "error"} {
+		var code statusCode
+		if err := code.Set(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
@@ -3,119 +3,219 @@ package backend
 
 import (
 	"context"
-	"fmt"
-	"net"
+	"net/http"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
+)
 
-	"github.com/rs/zerolog/log"
+// State describes the health of a backend as determined by health checks.
+type State int32
 
-	"net/http"
+const (
+	StateUnhealthy State = iota // Backend is down and receives no traffic
+	StateHealthy                // Backend is fully operational
+	StateDegraded               // Backend responds but only takes overflow traffic
 )
 
+// drainPollInterval is how often WaitDrained checks the in-flight count.
+const drainPollInterval = 50 * time.Millisecond
+
+// DegradedWeightFactor scales the weight of degraded backends so that they
+// receive a reduced share of traffic when they are in rotation.
+const DegradedWeightFactor = 0.25
+
+// String returns a human-readable name of the state.
+func (s State) String() string {
+	switch s {
+	case StateHealthy:
+		return "healthy"
+	case StateDegraded:
+		return "degraded"
+	default:
+		return "unhealthy"
+	}
+}
+
 // Backend represents a single backend server with health status tracking.
+// Addr identifies the backend and may be host:port or a URL (see ParseURL).
 // The Alive field uses atomic operations for thread-safe access.
 type Backend struct {
-	Addr  string // Address of the backend server (host:port)
-	Alive int32  // Health status: 1 for alive, 0 for dead (accessed atomically)
+	Addr   string // Address of the backend server (host:port or URL)
+	Alive  int32  // Health state as a State value: 1 healthy, 2 degraded, 0 dead (accessed atomically)
+	Weight int    // Relative weight for weighted strategies (0 means 1)
+
+	// MaxConns caps the number of requests proxied to the backend at once
+	// (0 means unlimited). See TryAcquire.
+	MaxConns int
+
+	// Priority groups backends for failover: only the lowest-valued group
+	// with available backends receives traffic (as with DNS SRV priorities).
+	Priority int
+
+	Zone string            // Availability zone the backend runs in
+	Tags map[string]string // Arbitrary key/value tags
+
+	Breaker          *CircuitBreaker  // Circuit breaker driven by live requests (nil disables)
+	TLS              *TLSConfig       // TLS settings for https backends (nil uses defaults)
+	TransportOptions TransportOptions // Connection tuning, fixed once the transport is built
+
+	inFlight int64 // Number of requests currently being proxied (accessed atomically)
+	tunnels  int64 // Number of upgraded connections open, also counted in inFlight (accessed atomically)
+	draining int32 // 1 while the backend is draining (accessed atomically)
+
+	transportOnce sync.Once
+	baseURL       *url.URL
+	transport     *http.Transport
+	transportErr  error
 }
 
-// IsAlive returns true if the backend is currently healthy and available.
+// New creates a backend for the given address. If breaker settings are
+// provided, the backend gets its own circuit breaker.
+func New(addr string, breaker *BreakerSettings) *Backend {
+	b := &Backend{Addr: addr}
+	if breaker != nil {
+		b.Breaker = NewCircuitBreaker(addr, *breaker)
+	}
+	return b
+}
+
+// State returns the current health state of the backend.
+// Thread-safe using atomic load operation.
+func (b *Backend) State() State {
+	return State(atomic.LoadInt32(&b.Alive))
+}
+
+// SetState updates the health state of the backend.
+// Thread-safe using atomic store operation.
+func (b *Backend) SetState(state State) {
+	atomic.StoreInt32(&b.Alive, int32(state))
+}
+
+// IsAlive returns true if the backend is currently able to serve requests,
+// either fully healthy or degraded.
 // Thread-safe using atomic load operation.
 func (b *Backend) IsAlive() bool {
-	return atomic.LoadInt32(&b.Alive) == 1
+	return b.State() != StateUnhealthy
+}
+
+// IsHealthy returns true if the backend is fully healthy.
+func (b *Backend) IsHealthy() bool {
+	return b.State() == StateHealthy
 }
 
-// SetAlive updates the health status of the backend.
+// IsDegraded returns true if the backend is reachable but degraded.
+func (b *Backend) IsDegraded() bool {
+	return b.State() == StateDegraded
+}
+
+// SetAlive updates the health status of the backend, marking it either
+// healthy or unhealthy.
 // Thread-safe using atomic store operation.
 func (b *Backend) SetAlive(state bool) {
-	var value int32
 	if state {
+		b.SetState(StateHealthy)
+		return
+	}
+	b.SetState(StateUnhealthy)
+}
+
+// SetDraining puts the backend into or out of draining mode. A draining
+// backend receives no new requests, but requests in flight run to completion.
+func (b *Backend) SetDraining(draining bool) {
+	var value int32
+	if draining {
 		value = 1
 	}
-	atomic.StoreInt32(&b.Alive, value)
-}
-
-// StartBackend starts HTTP servers for each backend in separate goroutines.
-// Each backend server provides a simple echo endpoint and a health check endpoint.
-// The function signals via the ready channel once all backends are listening and ready.
-//
-// Parameters:
-//   - ctx: Context for graceful shutdown
-//   - backends: Slice of backend servers to start
-//   - wg: WaitGroup to coordinate shutdown
-//   - ready: Channel to signal when all backends are ready (will be closed)
-func StartBackend(ctx context.Context, backends []*Backend, wg *sync.WaitGroup, ready chan<- struct{}) {
-	readyCount := 0
-	readyMu := sync.Mutex{}
-	totalBackends := len(backends)
-
-	for _, b := range backends {
-		wg.Add(1)
-		go func(b *Backend) {
-			defer wg.Done()
-			mux := http.NewServeMux()
-			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-				fmt.Fprintf(w, "Response from backend %s", b.Addr)
-			})
-			mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-			})
-
-			var listener net.Listener
-			var err error
-			addr := b.Addr
-			if addr == "" || addr == ":0" {
-				listener, err = net.Listen("tcp", ":0")
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to listen on a random port")
-					b.SetAlive(false)
-					return
-				}
-				b.Addr = listener.Addr().String()
-			} else {
-				listener, err = net.Listen("tcp", addr)
-				if err != nil {
-					log.Error().Err(err).Str("address", addr).Msg("Failed to listen")
-					b.SetAlive(false)
-					return
-				}
-			}
-
-			srv := &http.Server{
-				Handler: mux,
-			}
-
-			serverDone := make(chan struct{})
-			defer close(serverDone)
-
-			// Set alive only after successful listen
-			b.SetAlive(true)
-			log.Info().Str("address", b.Addr).Msg("Starting backend server")
-
-			// Notify that this backend is ready
-			readyMu.Lock()
-			readyCount++
-			if readyCount == totalBackends && ready != nil {
-				close(ready)
-			}
-			readyMu.Unlock()
-
-			go func() {
-				if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
-					log.Error().Err(err).Str("address", b.Addr).Msg("Failed to start backend server")
-				}
-			}()
-
-			<-ctx.Done()
-			log.Info().Str("address", b.Addr).Msg("Shutting down backend server")
-			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := srv.Shutdown(shutdownCtx); err != nil {
-				log.Error().Err(err).Str("address", b.Addr).Msg("Backend server shutdown failed")
-			}
-			log.Info().Str("address", b.Addr).Msg("Backend server stopped")
-		}(b)
+	atomic.StoreInt32(&b.draining, value)
+}
+
+// IsDraining returns true if the backend is draining.
+func (b *Backend) IsDraining() bool {
+	return atomic.LoadInt32(&b.draining) == 1
+}
+
+// IncInFlight records the start of a request proxied to the backend.
+func (b *Backend) IncInFlight() {
+	atomic.AddInt64(&b.inFlight, 1)
+}
+
+// DecInFlight records the end of a request proxied to the backend.
+func (b *Backend) DecInFlight() {
+	atomic.AddInt64(&b.inFlight, -1)
+}
+
+// TryAcquire records the start of a request unless the backend already has
+// MaxConns requests in flight. Callers that acquired a slot must release it
+// with DecInFlight.
+func (b *Backend) TryAcquire() bool {
+	for {
+		n := atomic.LoadInt64(&b.inFlight)
+		if b.MaxConns > 0 && n >= int64(b.MaxConns) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.inFlight, n, n+1) {
+			return true
+		}
+	}
+}
+
+// AtCapacity returns true if the backend has MaxConns requests in flight.
+func (b *Backend) AtCapacity() bool {
+	return b.MaxConns > 0 && b.InFlight() >= int64(b.MaxConns)
+}
+
+// InFlight returns the number of requests currently proxied to the backend.
+func (b *Backend) InFlight() int64 {
+	return atomic.LoadInt64(&b.inFlight)
+}
+
+// IncTunnels records a request to the backend being upgraded to a tunnel,
+// such as a WebSocket. The tunnel keeps the request's connection slot.
+func (b *Backend) IncTunnels() {
+	atomic.AddInt64(&b.tunnels, 1)
+}
+
+// DecTunnels records the close of a tunnel to the backend.
+func (b *Backend) DecTunnels() {
+	atomic.AddInt64(&b.tunnels, -1)
+}
+
+// Tunnels returns the number of upgraded connections open to the backend.
+func (b *Backend) Tunnels() int64 {
+	return atomic.LoadInt64(&b.tunnels)
+}
+
+// WaitDrained blocks until no requests are in flight to the backend or the
+// context is done, in which case the context error is returned.
+func (b *Backend) WaitDrained(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for b.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// EffectiveWeight returns the weight the backend should currently receive:
+// its configured weight when healthy, a reduced weight when degraded and
+// zero when unhealthy.
+func (b *Backend) EffectiveWeight() float64 {
+	weight := float64(b.Weight)
+	if weight <= 0 {
+		weight = 1
+	}
+	switch b.State() {
+	case StateHealthy:
+		return weight
+	case StateDegraded:
+		return weight * DegradedWeightFactor
+	default:
+		return 0
 	}
 }
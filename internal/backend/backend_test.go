@@ -2,9 +2,8 @@ package backend
 
 import (
 	"context"
-	"net/http"
-	"sync"
 	"testing"
+	"time"
 )
 
 func TestBackend_IsAlive(t *testing.T) {
@@ -20,32 +19,52 @@ func TestBackend_IsAlive(t *testing.T) {
 	}
 }
 
-func TestStartBackend(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
-	backend := &Backend{Addr: ":0"} // listen on random available port
-	ready := make(chan struct{})
-	StartBackend(ctx, []*Backend{backend}, &wg, ready)
+func TestBackend_WaitDrained(t *testing.T) {
+	b := &Backend{}
+	b.SetDraining(true)
+	if !b.IsDraining() {
+		t.Fatal("Expected backend to be draining")
+	}
 
-	// Wait for backend to be ready
-	<-ready
+	b.IncInFlight()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.WaitDrained(ctx); err == nil {
+		t.Fatal("Expected timeout while a request is in flight")
+	}
 
-	// Check if the server responds
-	resp, err := http.Get("http://" + backend.Addr + "/")
-	if err != nil {
-		cancel()
-		wg.Wait()
-		t.Fatalf("Failed to connect to backend: %v", err)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.DecInFlight()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.WaitDrained(ctx); err != nil {
+		t.Errorf("Expected backend to drain, got %v", err)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		cancel()
-		wg.Wait()
-		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+func TestBackend_TryAcquire(t *testing.T) {
+	b := &Backend{MaxConns: 2}
+	if !b.TryAcquire() || !b.TryAcquire() {
+		t.Fatal("Expected slots below the limit to be acquired")
+	}
+	if b.TryAcquire() {
+		t.Fatal("Expected acquire to fail at the limit")
+	}
+	if !b.AtCapacity() {
+		t.Error("Expected backend to be at capacity")
+	}
+
+	b.DecInFlight()
+	if b.AtCapacity() || !b.TryAcquire() {
+		t.Error("Expected a released slot to be available again")
 	}
 
-	// Shutdown and wait
-	cancel()
-	wg.Wait()
+	unlimited := &Backend{}
+	for i := 0; i < 100; i++ {
+		if !unlimited.TryAcquire() {
+			t.Fatal("Expected unlimited backend to always acquire")
+		}
+	}
 }
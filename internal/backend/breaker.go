@@ -0,0 +1,212 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Requests flow normally, failures are counted
+	BreakerOpen                         // Requests are rejected until the open duration elapses
+	BreakerHalfOpen                     // A limited number of probe requests are let through
+)
+
+// String returns a human-readable name of the breaker state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerSettings configures when a circuit breaker trips and recovers.
+type BreakerSettings struct {
+	FailureRatio   float64       // Share of failed requests in a window that opens the circuit
+	MinRequests    int           // Minimum requests in a window before the ratio is evaluated
+	Window         time.Duration // Length of the counting window in closed state
+	OpenDuration   time.Duration // How long the circuit stays open before probing
+	HalfOpenProbes int           // Number of probe requests allowed in half-open state
+}
+
+// CircuitBreaker tracks live request outcomes for a backend and stops
+// traffic to it when too many requests fail.
+// A nil *CircuitBreaker is valid and never rejects requests.
+type CircuitBreaker struct {
+	settings BreakerSettings
+	addr     string // Backend address, used for logging
+
+	mu          sync.Mutex
+	state       BreakerState
+	requests    int       // Requests in the current window (closed state)
+	failures    int       // Failures in the current window (closed state)
+	windowStart time.Time // Start of the current counting window
+	openedAt    time.Time // When the circuit was last opened
+	probes      int       // Probe requests in flight (half-open state)
+	successes   int       // Successful probes (half-open state)
+
+	now func() time.Time // Clock, replaceable in tests
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the backend at addr.
+func NewCircuitBreaker(addr string, settings BreakerSettings) *CircuitBreaker {
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = 1
+	}
+	cb := &CircuitBreaker{
+		settings: settings,
+		addr:     addr,
+		now:      time.Now,
+	}
+	cb.windowStart = cb.now()
+	return cb
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	if cb == nil {
+		return BreakerClosed
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	return cb.state
+}
+
+// Ready reports whether the breaker would currently let a request through.
+// Unlike Allow it does not reserve a half-open probe slot, so it can be used
+// when choosing between backends.
+func (cb *CircuitBreaker) Ready() bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	switch cb.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return cb.probes < cb.settings.HalfOpenProbes
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent to the backend. Every allowed
+// request must be followed by exactly one call to Record or Cancel.
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	switch cb.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if cb.probes >= cb.settings.HalfOpenProbes {
+			return false
+		}
+		cb.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// Record reports the outcome of a request previously allowed by Allow.
+func (cb *CircuitBreaker) Record(success bool) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+
+	switch cb.state {
+	case BreakerClosed:
+		cb.requests++
+		if !success {
+			cb.failures++
+		}
+		if cb.requests >= cb.settings.MinRequests &&
+			float64(cb.failures)/float64(cb.requests) >= cb.settings.FailureRatio {
+			cb.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		if !success {
+			cb.transition(BreakerOpen)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.settings.HalfOpenProbes {
+			cb.transition(BreakerClosed)
+		}
+	}
+}
+
+// Cancel returns the slot of a request previously allowed by Allow without
+// an outcome, for requests that say nothing about the backend's health, such
+// as ones the client abandoned or that were never sent.
+func (cb *CircuitBreaker) Cancel() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	if cb.state == BreakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// advance applies time-based transitions: resetting the counting window
+// and moving from open to half-open. Must be called with mu held.
+func (cb *CircuitBreaker) advance() {
+	now := cb.now()
+	switch cb.state {
+	case BreakerClosed:
+		if cb.settings.Window > 0 && now.Sub(cb.windowStart) >= cb.settings.Window {
+			cb.requests, cb.failures = 0, 0
+			cb.windowStart = now
+		}
+	case BreakerOpen:
+		if now.Sub(cb.openedAt) >= cb.settings.OpenDuration {
+			cb.transition(BreakerHalfOpen)
+		}
+	}
+}
+
+// transition moves the breaker to the given state and resets counters.
+// Must be called with mu held.
+func (cb *CircuitBreaker) transition(state BreakerState) {
+	now := cb.now()
+	log.Warn().
+		Str("backend", cb.addr).
+		Stringer("from", cb.state).
+		Stringer("to", state).
+		Int("requests", cb.requests).
+		Int("failures", cb.failures).
+		Msg("Circuit breaker state changed")
+
+	cb.state = state
+	cb.requests, cb.failures = 0, 0
+	cb.probes, cb.successes = 0, 0
+	cb.windowStart = now
+	if state == BreakerOpen {
+		cb.openedAt = now
+	}
+}
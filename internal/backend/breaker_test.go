@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBreaker(now *time.Time) *CircuitBreaker {
+	cb := NewCircuitBreaker("localhost:9001", BreakerSettings{
+		FailureRatio:   0.5,
+		MinRequests:    4,
+		Window:         time.Minute,
+		OpenDuration:   10 * time.Second,
+		HalfOpenProbes: 2,
+	})
+	cb.now = func() time.Time { return *now }
+	cb.windowStart = *now
+	return cb
+}
+
+func TestCircuitBreaker_OpensOnFailureRatio(t *testing.T) {
+	now := time.Now()
+	cb := newTestBreaker(&now)
+
+	for _, success := range []bool{true, false, true} {
+		cb.Allow()
+		cb.Record(success)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatal("Breaker should stay closed below minimum request volume")
+	}
+
+	cb.Allow()
+	cb.Record(false)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("Expected breaker to be open, got %s", cb.State())
+	}
+	if cb.Ready() || cb.Allow() {
+		t.Error("Open breaker should reject requests")
+	}
+}
+
+func TestCircuitBreaker_HalfOpenRecovery(t *testing.T) {
+	now := time.Now()
+	cb := newTestBreaker(&now)
+	for range 4 {
+		cb.Allow()
+		cb.Record(false)
+	}
+
+	now = now.Add(10 * time.Second)
+	if cb.State() != BreakerHalfOpen {
+		t.Fatalf("Expected breaker to be half-open, got %s", cb.State())
+	}
+
+	if !cb.Allow() || !cb.Allow() {
+		t.Fatal("Half-open breaker should allow probe requests")
+	}
+	if cb.Allow() {
+		t.Error("Half-open breaker should limit concurrent probes")
+	}
+
+	cb.Record(true)
+	cb.Record(true)
+	if cb.State() != BreakerClosed {
+		t.Errorf("Expected breaker to close after successful probes, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	now := time.Now()
+	cb := newTestBreaker(&now)
+	for range 4 {
+		cb.Allow()
+		cb.Record(false)
+	}
+
+	now = now.Add(10 * time.Second)
+	cb.Allow()
+	cb.Record(false)
+	if cb.State() != BreakerOpen {
+		t.Errorf("Expected breaker to reopen after failed probe, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreaker_Nil(t *testing.T) {
+	var cb *CircuitBreaker
+	if !cb.Allow() || !cb.Ready() || cb.State() != BreakerClosed {
+		t.Error("Nil breaker should always admit requests")
+	}
+	cb.Record(false)
+}
+
+func TestCircuitBreaker_CancelReturnsProbe(t *testing.T) {
+	now := time.Now()
+	cb := newTestBreaker(&now)
+	for range 4 {
+		cb.Allow()
+		cb.Record(false)
+	}
+
+	now = now.Add(10 * time.Second)
+	cb.Allow()
+	cb.Allow()
+	cb.Cancel()
+	cb.Cancel()
+	if cb.State() != BreakerHalfOpen {
+		t.Fatalf("Expected cancelled probes to keep the breaker half-open, got %s", cb.State())
+	}
+	for range 2 {
+		if !cb.Allow() {
+			t.Fatal("Expected cancelled probe slots to be available again")
+		}
+		cb.Record(true)
+	}
+	if cb.State() != BreakerClosed {
+		t.Errorf("Expected breaker to close after successful probes, got %s", cb.State())
+	}
+}
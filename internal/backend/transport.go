@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default transport timeouts shared by proxying and health checks.
+const (
+	connectTimeout        = 10 * time.Second
+	responseHeaderTimeout = 10 * time.Second
+	idleConnTimeout       = 30 * time.Second
+)
+
+// Protocols spoken to backends.
+const (
+	ProtocolHTTP1 = "http1" // HTTP/1.1 only (default)
+	ProtocolHTTP2 = "http2" // HTTP/2 negotiated over TLS, HTTP/1.1 for plain backends and as fallback
+	ProtocolH2C   = "h2c"   // Cleartext HTTP/2 with prior knowledge, for plain http and unix backends
+)
+
+// unixHost is the placeholder host used in request URLs for Unix socket backends.
+const unixHost = "unix"
+
+// TLSConfig holds TLS settings for connecting to an HTTPS backend.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file" yaml:"ca_file"`                           // PEM bundle of trusted CAs (system roots if empty)
+	ServerName         string `json:"server_name" yaml:"server_name"`                   // SNI and verification name override
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"` // Skip certificate verification (development only)
+}
+
+// TransportOptions tunes the connections to a backend. Zero values use the
+// defaults.
+type TransportOptions struct {
+	ConnectTimeout        time.Duration `json:"connect_timeout" yaml:"connect_timeout"`                 // Time to establish a connection (default 10s)
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout" yaml:"response_header_timeout"` // Time to wait for response headers (default 10s)
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout" yaml:"idle_conn_timeout"`             // How long idle connections are kept (default 30s)
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"` // Idle connections kept open (default 2)
+	Protocol              string        `json:"protocol" yaml:"protocol"`                               // http1, http2 or h2c (default http1)
+}
+
+// ValidateProtocol checks that name is a supported backend protocol. An
+// empty name selects the default.
+func ValidateProtocol(name string) error {
+	switch name {
+	case "", ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C:
+		return nil
+	default:
+		return fmt.Errorf("unknown backend protocol %q", name)
+	}
+}
+
+// ValidateTargetProtocol checks that the backend at addr can be reached
+// with the protocol, such as h2c, which cannot be used with https.
+func ValidateTargetProtocol(addr, protocol string) error {
+	u, err := ParseURL(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := transportProtocols(protocol, u.Scheme); err != nil {
+		return fmt.Errorf("backend %s: %w", addr, err)
+	}
+	return nil
+}
+
+// ParseURL parses a backend address. Supported forms are host:port (plain
+// HTTP), http:// and https:// URLs with an optional base path, and
+// unix:///path/to/socket for Unix domain sockets.
+func ParseURL(addr string) (*url.URL, error) {
+	if addr == "" {
+		return nil, errors.New("backend address cannot be empty")
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend address: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("backend address %q has no host", addr)
+		}
+	case "unix":
+		if u.Host != "" || u.Path == "" {
+			return nil, fmt.Errorf("unix backend %q must be of the form unix:///path/to/socket", addr)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported backend scheme %q", u.Scheme)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("backend address %q cannot have a query or fragment", addr)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+// URL returns the base URL requests to the backend are sent to. For Unix
+// socket backends the URL uses plain HTTP with a placeholder host, as the
+// transport dials the socket directly.
+func (b *Backend) URL() (*url.URL, error) {
+	b.initTransport()
+	if b.transportErr != nil {
+		return nil, b.transportErr
+	}
+	return b.baseURL, nil
+}
+
+// Transport returns the HTTP transport for the backend, shared by the
+// reverse proxy and health checks so both use the same connection settings.
+func (b *Backend) Transport() (*http.Transport, error) {
+	b.initTransport()
+	return b.transport, b.transportErr
+}
+
+// ResponseHeaderTimeout returns how long the backend may take to send
+// response headers. The proxy enforces it for every attempt instead of the
+// transport, so a route can wait longer than the backend's default.
+func (b *Backend) ResponseHeaderTimeout() time.Duration {
+	return cmp.Or(b.TransportOptions.ResponseHeaderTimeout, responseHeaderTimeout)
+}
+
+// CloseIdleConnections closes idle connections to the backend.
+func (b *Backend) CloseIdleConnections() {
+	if transport, err := b.Transport(); err == nil {
+		transport.CloseIdleConnections()
+	}
+}
+
+// initTransport parses the address and builds the transport once.
+func (b *Backend) initTransport() {
+	b.transportOnce.Do(func() {
+		b.baseURL, b.transport, b.transportErr = newTransport(b.Addr, b.TLS, b.TransportOptions)
+	})
+}
+
+// newTransport builds the base URL and transport for a backend address.
+func newTransport(addr string, tlsConfig *TLSConfig, options TransportOptions) (*url.URL, *http.Transport, error) {
+	u, err := ParseURL(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	protocols, err := transportProtocols(options.Protocol, u.Scheme)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: cmp.Or(options.ConnectTimeout, connectTimeout)}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		IdleConnTimeout:     cmp.Or(options.IdleConnTimeout, idleConnTimeout),
+		MaxIdleConnsPerHost: options.MaxIdleConnsPerHost,
+		Protocols:           protocols,
+	}
+
+	if u.Scheme == "unix" {
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return &url.URL{Scheme: "http", Host: unixHost}, transport, nil
+	}
+
+	if u.Scheme == "https" && tlsConfig != nil {
+		clientConfig, err := tlsConfig.clientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		transport.TLSClientConfig = clientConfig
+	}
+	return u, transport, nil
+}
+
+// transportProtocols returns the protocols a transport speaks to a backend
+// with the URL scheme.
+func transportProtocols(name, scheme string) (*http.Protocols, error) {
+	if err := ValidateProtocol(name); err != nil {
+		return nil, err
+	}
+	protocols := new(http.Protocols)
+	switch name {
+	case ProtocolHTTP2:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		if scheme == "https" {
+			return nil, errors.New("h2c cannot be used with https backends, use http2 instead")
+		}
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+	}
+	return protocols, nil
+}
+
+// clientConfig converts the settings into a crypto/tls client configuration.
+func (c *TLSConfig) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
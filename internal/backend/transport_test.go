@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"localhost:9001", "http://localhost:9001"},
+		{"https://api.internal:8443", "https://api.internal:8443"},
+		{"http://api.internal/base/path/", "http://api.internal/base/path"},
+		{"unix:///run/app.sock", "unix:///run/app.sock"},
+	}
+	for _, tt := range tests {
+		u, err := ParseURL(tt.addr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.addr, err)
+			continue
+		}
+		if u.String() != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.addr, tt.expected, u)
+		}
+	}
+
+	for _, addr := range []string{"", "ftp://host", "unix://relative.sock", "http://host/?q=1"} {
+		if _, err := ParseURL(addr); err == nil {
+			t.Errorf("%q: expected error", addr)
+		}
+	}
+}
+
+func get(t *testing.T, b *Backend, path string) string {
+	t.Helper()
+	baseURL, err := b.URL()
+	if err != nil {
+		t.Fatalf("Failed to get backend URL: %v", err)
+	}
+	transport, err := b.Transport()
+	if err != nil {
+		t.Fatalf("Failed to create transport: %v", err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(baseURL.JoinPath(path).String())
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestTransport_BasePath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	b := &Backend{Addr: server.URL + "/base"}
+	if path := get(t, b, "/health"); path != "/base/health" {
+		t.Errorf("Expected /base/health, got %s", path)
+	}
+}
+
+func TestTransport_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets not supported: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("unix ok"))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	b := &Backend{Addr: "unix://" + socket}
+	if body := get(t, b, "/"); body != "unix ok" {
+		t.Errorf("Expected response over unix socket, got %q", body)
+	}
+}
+
+func TestTransport_TLSWithCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls ok"))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+
+	// httptest certificates are issued for example.com
+	b := &Backend{Addr: server.URL, TLS: &TLSConfig{CAFile: caFile, ServerName: "example.com"}}
+	if body := get(t, b, "/"); body != "tls ok" {
+		t.Errorf("Expected response over TLS, got %q", body)
+	}
+
+	untrusted := &Backend{Addr: server.URL}
+	transport, _ := untrusted.Transport()
+	if _, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		t.Error("Expected certificate verification to fail without the CA bundle")
+	}
+}
+
+func TestTransport_Protocols(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	tlsServer := httptest.NewUnstartedServer(proto)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	h2cServer := httptest.NewUnstartedServer(proto)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetHTTP1(true)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	insecure := &TLSConfig{InsecureSkipVerify: true}
+	tests := []struct {
+		name     string
+		backend  *Backend
+		expected string
+	}{
+		{"default over TLS", &Backend{Addr: tlsServer.URL, TLS: insecure}, "HTTP/1.1"},
+		{"http2 over TLS", &Backend{Addr: tlsServer.URL, TLS: insecure, TransportOptions: TransportOptions{Protocol: ProtocolHTTP2}}, "HTTP/2.0"},
+		{"http2 over plain http", &Backend{Addr: h2cServer.URL, TransportOptions: TransportOptions{Protocol: ProtocolHTTP2}}, "HTTP/1.1"},
+		{"h2c", &Backend{Addr: h2cServer.URL, TransportOptions: TransportOptions{Protocol: ProtocolH2C}}, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		if proto := get(t, tt.backend, "/"); proto != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, proto)
+		}
+	}
+
+	for _, b := range []*Backend{
+		{Addr: tlsServer.URL, TransportOptions: TransportOptions{Protocol: ProtocolH2C}},
+		{Addr: h2cServer.URL, TransportOptions: TransportOptions{Protocol: "spdy"}},
+	} {
+		if _, err := b.Transport(); err == nil {
+			t.Errorf("%s with %s: expected error", b.Addr, b.TransportOptions.Protocol)
+		}
+	}
+}
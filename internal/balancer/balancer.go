@@ -1,13 +1,27 @@
 // Package balancer provides load balancing functionality with pluggable strategies.
 package balancer
 
-import "load-balancer/internal/backend"
+import (
+	"errors"
+	"load-balancer/internal/backend"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrBackendExists is returned when adding a backend whose address is already registered.
+	ErrBackendExists = errors.New("backend already exists")
+	// ErrBackendNotFound is returned when a requested backend is not registered.
+	ErrBackendNotFound = errors.New("backend not found")
+)
 
 // Balancer distributes incoming requests across multiple backend servers
 // using a configurable balancing strategy.
+// The backend set can be changed at runtime.
 type Balancer struct {
 	strategy Strategy
 	backends []*backend.Backend
+	mu       sync.RWMutex
 }
 
 // NewBalancer creates a new load balancer with the given strategy and backends.
@@ -18,6 +32,12 @@ func NewBalancer(strategy Strategy, backends []*backend.Backend) *Balancer {
 	}
 }
 
+// Next returns the next backend accepted by the filter, according to the
+// configured balancing strategy. Returns nil if no backends are available.
+func (b *Balancer) Next(filter Filter) *backend.Backend {
+	return b.strategy.Next(filter)
+}
+
 // GetNext returns the address of the next backend to use for a request,
 // according to the configured balancing strategy.
 // Returns an empty string if no backends are available.
@@ -25,7 +45,52 @@ func (b *Balancer) GetNext() string {
 	return b.strategy.GetNext()
 }
 
-// GetBackends returns the list of all backends managed by this balancer.
+// GetBackends returns a snapshot of all backends managed by this balancer.
 func (b *Balancer) GetBackends() []*backend.Backend {
-	return b.backends
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.backends)
+}
+
+// GetBackend returns the backend with the given address.
+func (b *Balancer) GetBackend(addr string) (*backend.Backend, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, be := range b.backends {
+		if be.Addr == addr {
+			return be, nil
+		}
+	}
+	return nil, ErrBackendNotFound
+}
+
+// AddBackend registers a new backend and makes it available to the strategy.
+func (b *Balancer) AddBackend(be *backend.Backend) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, existing := range b.backends {
+		if existing.Addr == be.Addr {
+			return ErrBackendExists
+		}
+	}
+	b.backends = append(slices.Clone(b.backends), be)
+	b.strategy.SetBackends(b.backends)
+	return nil
+}
+
+// RemoveBackend unregisters the backend with the given address so that no
+// new requests are routed to it. Requests already in flight are unaffected.
+func (b *Balancer) RemoveBackend(addr string) (*backend.Backend, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	idx := slices.IndexFunc(b.backends, func(be *backend.Backend) bool {
+		return be.Addr == addr
+	})
+	if idx < 0 {
+		return nil, ErrBackendNotFound
+	}
+	removed := b.backends[idx]
+	b.backends = slices.Delete(slices.Clone(b.backends), idx, idx+1)
+	b.strategy.SetBackends(b.backends)
+	return removed, nil
 }
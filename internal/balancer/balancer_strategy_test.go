@@ -3,6 +3,7 @@ package balancer
 import (
 	"load-balancer/internal/backend"
 	"testing"
+	"time"
 )
 
 func TestRoundRobinStrategy_GetNext(t *testing.T) {
@@ -34,3 +35,171 @@ func TestRoundRobinStrategy_NoAliveBackends(t *testing.T) {
 		t.Errorf("Expected empty string, got %s", actual)
 	}
 }
+
+func TestRoundRobinStrategy_DegradedOnlyWhenHealthyInsufficient(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: int32(backend.StateHealthy)},
+		{Addr: "localhost:9002", Alive: int32(backend.StateHealthy)},
+		{Addr: "localhost:9003", Alive: int32(backend.StateDegraded)},
+	}
+	strategy := NewRoundRobinStrategy(backends)
+
+	for i := 0; i < 6; i++ {
+		if actual := strategy.GetNext(); actual == "localhost:9003" {
+			t.Fatalf("Test %d: degraded backend selected while healthy capacity is sufficient", i)
+		}
+	}
+
+	backends[1].SetState(backend.StateUnhealthy)
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		seen[strategy.GetNext()] = true
+	}
+	if !seen["localhost:9003"] {
+		t.Error("Expected degraded backend to be used when healthy capacity is insufficient")
+	}
+}
+
+func TestRoundRobinStrategy_DegradedReducedShare(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: int32(backend.StateHealthy), Weight: 3},
+		{Addr: "localhost:9002", Alive: int32(backend.StateDegraded)},
+		{Addr: "localhost:9003", Alive: int32(backend.StateDegraded)},
+		{Addr: "localhost:9004", Alive: int32(backend.StateUnhealthy), Weight: 3},
+	}
+	strategy := NewRoundRobinStrategy(backends)
+
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		counts[strategy.GetNext()]++
+	}
+	if counts["localhost:9001"] != 20 || counts["localhost:9002"] != 5 || counts["localhost:9003"] != 5 {
+		t.Errorf("Expected 20/5/5 distribution, got %v", counts)
+	}
+
+	// Without degraded backends the rotation is even again
+	backends[1].SetState(backend.StateUnhealthy)
+	backends[2].SetState(backend.StateUnhealthy)
+	backends[3].SetState(backend.StateHealthy)
+	counts = make(map[string]int)
+	for i := 0; i < 4; i++ {
+		counts[strategy.GetNext()]++
+	}
+	if counts["localhost:9001"] != 2 || counts["localhost:9004"] != 2 {
+		t.Errorf("Expected 2/2 distribution, got %v", counts)
+	}
+}
+
+func TestWeightedRoundRobinStrategy_GetNext(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: int32(backend.StateHealthy), Weight: 3},
+		{Addr: "localhost:9002", Alive: int32(backend.StateHealthy), Weight: 1},
+	}
+	strategy := NewWeightedRoundRobinStrategy(backends)
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[strategy.GetNext()]++
+	}
+	if counts["localhost:9001"] != 6 || counts["localhost:9002"] != 2 {
+		t.Errorf("Expected 6/2 distribution, got %v", counts)
+	}
+}
+
+func TestWeightedRoundRobinStrategy_DegradedReducedWeight(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: int32(backend.StateHealthy)},
+		{Addr: "localhost:9002", Alive: int32(backend.StateDegraded), Weight: 2},
+		{Addr: "localhost:9003", Alive: int32(backend.StateUnhealthy)},
+	}
+	strategy := NewWeightedRoundRobinStrategy(backends)
+
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		counts[strategy.GetNext()]++
+	}
+	if counts["localhost:9002"] != 10 || counts["localhost:9001"] != 20 {
+		t.Errorf("Expected 20/10 distribution, got %v", counts)
+	}
+}
+
+func TestRoundRobinStrategy_SkipsOpenCircuit(t *testing.T) {
+	breaker := backend.NewCircuitBreaker("localhost:9002", backend.BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  1,
+		OpenDuration: time.Minute,
+	})
+	breaker.Allow()
+	breaker.Record(false)
+
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: 1},
+		{Addr: "localhost:9002", Alive: 1, Breaker: breaker},
+	}
+	strategy := NewRoundRobinStrategy(backends)
+
+	for i := 0; i < 3; i++ {
+		if actual := strategy.GetNext(); actual != "localhost:9001" {
+			t.Errorf("Test %d: expected localhost:9001, got %s", i, actual)
+		}
+	}
+}
+
+func TestRoundRobinStrategy_SkipsDraining(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: 1},
+		{Addr: "localhost:9002", Alive: 1},
+	}
+	backends[0].SetDraining(true)
+	strategy := NewRoundRobinStrategy(backends)
+
+	for i := 0; i < 3; i++ {
+		if actual := strategy.GetNext(); actual != "localhost:9002" {
+			t.Errorf("Test %d: expected localhost:9002, got %s", i, actual)
+		}
+	}
+}
+
+func TestRoundRobinStrategy_PriorityFailover(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: 1, Priority: 10},
+		{Addr: "localhost:9002", Alive: 1, Priority: 20},
+	}
+	strategy := NewRoundRobinStrategy(backends)
+
+	for i := 0; i < 2; i++ {
+		if actual := strategy.GetNext(); actual != "localhost:9001" {
+			t.Errorf("Test %d: expected primary localhost:9001, got %s", i, actual)
+		}
+	}
+
+	backends[0].SetAlive(false)
+	if actual := strategy.GetNext(); actual != "localhost:9002" {
+		t.Errorf("Expected failover to localhost:9002, got %s", actual)
+	}
+}
+
+func TestRoundRobinStrategy_MatchTags(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: 1, Tags: map[string]string{"track": "stable"}},
+		{Addr: "localhost:9002", Alive: 1, Tags: map[string]string{"track": "canary", "zone": "eu-1"}},
+		{Addr: "localhost:9003", Alive: 1},
+	}
+	strategy := NewRoundRobinStrategy(backends)
+
+	canary := MatchTags(map[string]string{"track": "canary"})
+	for i := 0; i < 3; i++ {
+		if actual := strategy.Next(canary); actual == nil || actual.Addr != "localhost:9002" {
+			t.Fatalf("Test %d: expected canary backend, got %v", i, actual)
+		}
+	}
+
+	if actual := strategy.Next(MatchTags(map[string]string{"track": "beta"})); actual != nil {
+		t.Errorf("Expected no backend for unmatched tags, got %s", actual.Addr)
+	}
+
+	backends[1].SetDraining(true)
+	if actual := strategy.Next(canary); actual != nil {
+		t.Errorf("Expected no backend when the only match is draining, got %s", actual.Addr)
+	}
+}
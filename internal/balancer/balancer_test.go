@@ -0,0 +1,43 @@
+package balancer
+
+import (
+	"errors"
+	"load-balancer/internal/backend"
+	"testing"
+)
+
+func TestBalancer_AddRemoveBackend(t *testing.T) {
+	backends := []*backend.Backend{
+		{Addr: "localhost:9001", Alive: 1},
+	}
+	lb := NewBalancer(NewRoundRobinStrategy(backends), backends)
+
+	added := &backend.Backend{Addr: "localhost:9002", Alive: 1}
+	if err := lb.AddBackend(added); err != nil {
+		t.Fatalf("Failed to add backend: %v", err)
+	}
+	if err := lb.AddBackend(&backend.Backend{Addr: "localhost:9002"}); !errors.Is(err, ErrBackendExists) {
+		t.Errorf("Expected ErrBackendExists, got %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		seen[lb.GetNext()] = true
+	}
+	if !seen["localhost:9002"] {
+		t.Error("Expected added backend to receive traffic")
+	}
+
+	removed, err := lb.RemoveBackend("localhost:9001")
+	if err != nil || removed.Addr != "localhost:9001" {
+		t.Fatalf("Failed to remove backend: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if actual := lb.GetNext(); actual != "localhost:9002" {
+			t.Errorf("Test %d: expected localhost:9002, got %s", i, actual)
+		}
+	}
+	if _, err := lb.RemoveBackend("localhost:9001"); !errors.Is(err, ErrBackendNotFound) {
+		t.Errorf("Expected ErrBackendNotFound, got %v", err)
+	}
+}
@@ -2,24 +2,133 @@ package balancer
 
 import (
 	"load-balancer/internal/backend"
+	"maps"
+	"slices"
 	"sync"
 
 	"github.com/rs/zerolog/log"
 )
 
+// minHealthyRatio is the share of total configured weight that healthy
+// backends must provide. Below it, degraded backends are added to the
+// rotation to make up for the missing capacity.
+const minHealthyRatio = 0.5
+
+// Filter restricts which backends may be selected for a request.
+// A nil Filter accepts every backend.
+type Filter func(b *backend.Backend) bool
+
+// MatchTags returns a filter accepting backends that carry all given tags.
+func MatchTags(tags map[string]string) Filter {
+	return func(b *backend.Backend) bool {
+		for key, value := range tags {
+			if actual, ok := b.Tags[key]; !ok || actual != value {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 // Strategy defines the interface for load balancing strategies.
 // Implementations must be thread-safe.
 type Strategy interface {
+	// Next returns the next backend accepted by the filter.
+	// Returns nil if no backends are available.
+	Next(filter Filter) *backend.Backend
+
 	// GetNext returns the address of the next backend to use.
 	// Returns empty string if no backends are available.
 	GetNext() string
+
+	// SetBackends replaces the set of backends the strategy selects from.
+	SetBackends(backends []*backend.Backend)
+}
+
+// filtered returns the backends accepted by the filter.
+func filtered(backends []*backend.Backend, filter Filter) []*backend.Backend {
+	if filter == nil {
+		return backends
+	}
+	var accepted []*backend.Backend
+	for _, b := range backends {
+		if filter(b) {
+			accepted = append(accepted, b)
+		}
+	}
+	return accepted
+}
+
+// eligible returns the backends that may receive traffic right now.
+// Healthy backends are always eligible; degraded backends are only used
+// when healthy capacity falls below minHealthyRatio of the total.
+// Draining backends, backends with an open circuit breaker and backends at
+// their connection limit are never eligible.
+// Only backends of the lowest priority that has eligible backends are returned.
+func eligible(backends []*backend.Backend) []*backend.Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	priority := backends[0].Priority
+	same := true
+	for _, b := range backends {
+		if b.Priority != priority {
+			same = false
+			break
+		}
+	}
+	if same {
+		return eligibleInGroup(backends)
+	}
+
+	groups := make(map[int][]*backend.Backend)
+	for _, b := range backends {
+		groups[b.Priority] = append(groups[b.Priority], b)
+	}
+	for _, p := range slices.Sorted(maps.Keys(groups)) {
+		if candidates := eligibleInGroup(groups[p]); len(candidates) > 0 {
+			return candidates
+		}
+	}
+	return nil
+}
+
+// eligibleInGroup applies the health, draining and circuit breaker rules
+// to a single priority group.
+func eligibleInGroup(backends []*backend.Backend) []*backend.Backend {
+	var healthy, degraded []*backend.Backend
+	var healthyWeight, totalWeight float64
+	for _, b := range backends {
+		if b.IsDraining() {
+			continue
+		}
+		weight := float64(max(b.Weight, 1))
+		totalWeight += weight
+		if !b.Breaker.Ready() || b.AtCapacity() {
+			continue
+		}
+		switch b.State() {
+		case backend.StateHealthy:
+			healthy = append(healthy, b)
+			healthyWeight += weight
+		case backend.StateDegraded:
+			degraded = append(degraded, b)
+		}
+	}
+	if len(degraded) > 0 && healthyWeight < totalWeight*minHealthyRatio {
+		return append(healthy, degraded...)
+	}
+	return healthy
 }
 
 // RoundRobinStrategy implements a round-robin load balancing strategy.
 // It distributes requests evenly across all healthy backends in a circular manner.
+// Degraded backends in the rotation get DegradedWeightFactor of a healthy
+// backend's share.
 type RoundRobinStrategy struct {
 	backends []*backend.Backend
 	index    int
+	current  map[*backend.Backend]float64 // Running weight per backend while degraded backends are in the rotation
 	mutex    sync.Mutex
 }
 
@@ -27,30 +136,134 @@ type RoundRobinStrategy struct {
 func NewRoundRobinStrategy(backends []*backend.Backend) *RoundRobinStrategy {
 	return &RoundRobinStrategy{
 		backends: backends,
+		current:  make(map[*backend.Backend]float64),
 	}
 }
 
+func (r *RoundRobinStrategy) SetBackends(backends []*backend.Backend) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.backends = backends
+	r.index = 0
+	clear(r.current)
+}
+
 func (r *RoundRobinStrategy) GetNext() string {
+	return addrOf(r.Next(nil))
+}
+
+func (r *RoundRobinStrategy) Next(filter Filter) *backend.Backend {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	numBackends := len(r.backends)
 	if numBackends == 0 {
 		log.Warn().Msg("No backends configured")
-		return ""
+		return nil
+	}
+
+	candidates := eligible(filtered(r.backends, filter))
+	if len(candidates) == 0 {
+		log.Warn().Msg("No available backends found")
+		return nil
 	}
 
-	startIndex := r.index
-	for i := 0; i < numBackends; i++ {
-		idx := (startIndex + i) % numBackends
-		if r.backends[idx].IsAlive() {
-			r.index = (idx + 1) % numBackends
-			log.Debug().
-				Str("selected_backend", r.backends[idx].Addr).
-				Msg("Selected backend for request")
-			return r.backends[idx].Addr
+	var selected *backend.Backend
+	if slices.ContainsFunc(candidates, isDegraded) {
+		// Configured weights are ignored, only the degraded share is reduced
+		selected = smoothWeighted(candidates, r.current, func(b *backend.Backend) float64 {
+			if isDegraded(b) {
+				return backend.DegradedWeightFactor
+			}
+			return 1
+		})
+	} else {
+		selected = candidates[r.index%len(candidates)]
+		r.index = (r.index + 1) % len(candidates)
+	}
+	log.Debug().
+		Str("selected_backend", selected.Addr).
+		Stringer("state", selected.State()).
+		Msg("Selected backend for request")
+	return selected
+}
+
+// WeightedRoundRobinStrategy implements smooth weighted round-robin.
+// Each backend receives a share of requests proportional to its effective
+// weight, so degraded backends get less traffic than healthy ones.
+type WeightedRoundRobinStrategy struct {
+	backends []*backend.Backend
+	current  map[*backend.Backend]float64 // Running weight per backend
+	mutex    sync.Mutex
+}
+
+// NewWeightedRoundRobinStrategy creates a new smooth weighted round-robin strategy.
+func NewWeightedRoundRobinStrategy(backends []*backend.Backend) *WeightedRoundRobinStrategy {
+	return &WeightedRoundRobinStrategy{
+		backends: backends,
+		current:  make(map[*backend.Backend]float64),
+	}
+}
+
+func (w *WeightedRoundRobinStrategy) SetBackends(backends []*backend.Backend) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.backends = backends
+	for b := range w.current {
+		if !slices.Contains(backends, b) {
+			delete(w.current, b)
 		}
 	}
-	log.Warn().Msg("No available backends found")
-	return ""
+}
+
+func (w *WeightedRoundRobinStrategy) GetNext() string {
+	return addrOf(w.Next(nil))
+}
+
+func (w *WeightedRoundRobinStrategy) Next(filter Filter) *backend.Backend {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	candidates := eligible(filtered(w.backends, filter))
+	if len(candidates) == 0 {
+		log.Warn().Msg("No available backends found")
+		return nil
+	}
+
+	selected := smoothWeighted(candidates, w.current, (*backend.Backend).EffectiveWeight)
+	log.Debug().
+		Str("selected_backend", selected.Addr).
+		Stringer("state", selected.State()).
+		Msg("Selected backend for request")
+	return selected
+}
+
+// smoothWeighted selects a candidate by smooth weighted round-robin,
+// keeping the running weight of each backend in current.
+func smoothWeighted(candidates []*backend.Backend, current map[*backend.Backend]float64, weightOf func(*backend.Backend) float64) *backend.Backend {
+	var selected *backend.Backend
+	var total float64
+	for _, b := range candidates {
+		weight := weightOf(b)
+		total += weight
+		current[b] += weight
+		if selected == nil || current[b] > current[selected] {
+			selected = b
+		}
+	}
+	current[selected] -= total
+	return selected
+}
+
+// isDegraded reports whether the backend is degraded.
+func isDegraded(b *backend.Backend) bool {
+	return b.State() == backend.StateDegraded
+}
+
+// addrOf returns the address of b, or an empty string if b is nil.
+func addrOf(b *backend.Backend) string {
+	if b == nil {
+		return ""
+	}
+	return b.Addr
 }
@@ -2,8 +2,19 @@
 package config
 
 import (
+	"cmp"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/pool"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -11,11 +22,86 @@ import (
 // Config holds the application configuration loaded from environment or config file.
 type Config struct {
 	ListenAddress       string   `mapstructure:"LISTEN_ADDRESS"`         // Address to listen on (host:port)
+	AdminListenAddress  string   `mapstructure:"ADMIN_LISTEN_ADDRESS"`   // Address of the admin API (disabled if empty)
+	TLSCertFile         string   `mapstructure:"TLS_CERT_FILE"`          // Certificate served to clients; enables HTTPS with TLSKeyFile
+	TLSKeyFile          string   `mapstructure:"TLS_KEY_FILE"`           // Private key of TLSCertFile
+	TLSMinVersion       string   `mapstructure:"TLS_MIN_VERSION"`        // Minimum TLS version accepted from clients: 1.0 to 1.3
+	TLSCipherSuites     []string `mapstructure:"TLS_CIPHER_SUITES"`      // TLS 1.2 cipher suites offered to clients (Go defaults if empty)
+	ServerHTTP2         bool     `mapstructure:"SERVER_HTTP2"`           // Serve HTTP/2 to clients over TLS
+	ServerH2C           bool     `mapstructure:"SERVER_H2C"`             // Serve cleartext HTTP/2 with prior knowledge
 	Backends            []string `mapstructure:"BACKENDS"`               // List of backend server addresses
 	RateLimitCapacity   float64  `mapstructure:"RATE_LIMIT_CAPACITY"`    // Default rate limit bucket capacity
 	RateLimitRefillRate float64  `mapstructure:"RATE_LIMIT_REFILL_RATE"` // Default rate limit refill rate
+	RateLimitPerIP      bool     `mapstructure:"RATE_LIMIT_PER_IP"`      // Also limit each client IP with the default capacity and refill rate
+	BalanceStrategy     string   `mapstructure:"BALANCE_STRATEGY"`       // Balancing strategy: round_robin or weighted_round_robin
+	GRPCAPIKeyMetadata  string   `mapstructure:"GRPC_API_KEY_METADATA"`  // gRPC metadata carrying the API key when X-API-Key is absent
+
+	HealthCheckPath           string        `mapstructure:"HEALTH_CHECK_PATH"`       // Health endpoint path on backends
+	HealthCheckInterval       time.Duration `mapstructure:"HEALTH_CHECK_INTERVAL"`   // Time between health checks
+	HealthDegradedLatency     time.Duration `mapstructure:"HEALTH_DEGRADED_LATENCY"` // Probe latency above which a backend is degraded (0 disables)
+	HealthDegradedStatusCodes []int         `mapstructure:"-"`                       // Probe status codes that mark a backend degraded (HEALTH_DEGRADED_STATUS_CODES)
+	HealthDegradedBody        string        `mapstructure:"HEALTH_DEGRADED_BODY"`    // Probe body substring that marks a backend degraded
+
+	BreakerFailureRatio   float64       `mapstructure:"CIRCUIT_BREAKER_FAILURE_RATIO"`    // Failure ratio that opens a backend's circuit (0 disables)
+	BreakerMinRequests    int           `mapstructure:"CIRCUIT_BREAKER_MIN_REQUESTS"`     // Minimum requests per window before the ratio applies
+	BreakerWindow         time.Duration `mapstructure:"CIRCUIT_BREAKER_WINDOW"`           // Window over which failures are counted
+	BreakerOpenDuration   time.Duration `mapstructure:"CIRCUIT_BREAKER_OPEN_DURATION"`    // How long a circuit stays open before probing
+	BreakerHalfOpenProbes int           `mapstructure:"CIRCUIT_BREAKER_HALF_OPEN_PROBES"` // Probe requests allowed while half-open
+
+	DrainTimeout time.Duration `mapstructure:"DRAIN_TIMEOUT"` // Maximum time to wait for in-flight requests of a removed backend
+
+	ServerReadTimeout       time.Duration `mapstructure:"SERVER_READ_TIMEOUT"`        // Time to read a client request, including the body
+	ServerReadHeaderTimeout time.Duration `mapstructure:"SERVER_READ_HEADER_TIMEOUT"` // Time to read client request headers
+	ServerWriteTimeout      time.Duration `mapstructure:"SERVER_WRITE_TIMEOUT"`       // Time to write a response; routes may override it
+	ServerIdleTimeout       time.Duration `mapstructure:"SERVER_IDLE_TIMEOUT"`        // How long idle client connections are kept
+
+	BackendConnectTimeout        time.Duration `mapstructure:"BACKEND_CONNECT_TIMEOUT"`         // Time to connect to a backend
+	BackendResponseHeaderTimeout time.Duration `mapstructure:"BACKEND_RESPONSE_HEADER_TIMEOUT"` // Time to wait for backend response headers
+	BackendIdleConnTimeout       time.Duration `mapstructure:"BACKEND_IDLE_CONN_TIMEOUT"`       // How long idle backend connections are kept
+	RequestTimeout               time.Duration `mapstructure:"REQUEST_TIMEOUT"`                 // Total time allowed for a request (0 disables)
+	BackendProtocol              string        `mapstructure:"BACKEND_PROTOCOL"`                // Protocol spoken to backends: http1, http2 or h2c
+	TunnelIdleTimeout            time.Duration `mapstructure:"TUNNEL_IDLE_TIMEOUT"`             // How long upgraded connections may go without traffic (0 disables)
+	StreamMaxDuration            time.Duration `mapstructure:"STREAM_MAX_DURATION"`             // Maximum duration of a streamed response (0 disables)
+
+	BackendMaxConns int           `mapstructure:"BACKEND_MAX_CONNS"` // Default concurrent request limit per backend (0 means unlimited)
+	QueueSize       int           `mapstructure:"QUEUE_SIZE"`        // Requests that may wait for a backend at its limit (0 rejects at once)
+	QueueTimeout    time.Duration `mapstructure:"QUEUE_TIMEOUT"`     // Maximum time a request waits in the queue
+
+	RetryAttempts         int           `mapstructure:"RETRY_ATTEMPTS"`           // Retries on another backend per request (0 disables)
+	RetryStatusCodes      []int         `mapstructure:"-"`                        // Backend response codes that are retried (RETRY_STATUS_CODES)
+	RetryPerTryTimeout    time.Duration `mapstructure:"RETRY_PER_TRY_TIMEOUT"`    // Time to response headers per attempt (0 disables)
+	RetrySafeHeader       string        `mapstructure:"RETRY_SAFE_HEADER"`        // Header marking non-idempotent requests as safe to retry
+	RetryBudgetRatio      float64       `mapstructure:"RETRY_BUDGET_RATIO"`       // Maximum share of retries among requests in a window
+	RetryBudgetMinRetries int           `mapstructure:"RETRY_BUDGET_MIN_RETRIES"` // Retries always allowed per window
+	RetryBudgetWindow     time.Duration `mapstructure:"RETRY_BUDGET_WINDOW"`      // Window over which the budget is counted
+
+	RequestBufferMemory    int64 `mapstructure:"REQUEST_BUFFER_MEMORY"`     // Request body bytes buffered in memory for retries (0 disables)
+	RequestBufferFileLimit int64 `mapstructure:"REQUEST_BUFFER_FILE_LIMIT"` // Larger bodies up to this size spill to a temp file (0 disables)
+
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"` // Proxy addresses or CIDRs whose forwarding headers are honoured
+
+	BackendTLSCAFile             string `mapstructure:"BACKEND_TLS_CA_FILE"`              // CA bundle for verifying https backends
+	BackendTLSServerName         string `mapstructure:"BACKEND_TLS_SERVER_NAME"`          // SNI override for https backends
+	BackendTLSInsecureSkipVerify bool   `mapstructure:"BACKEND_TLS_INSECURE_SKIP_VERIFY"` // Skip backend certificate verification (development only)
+
+	ConfigFile string     `mapstructure:"CONFIG_FILE"` // YAML file with structured settings such as tagged backends (empty disables)
+	File       FileConfig `mapstructure:"-"`           // Settings read from ConfigFile
+
+	DiscoveryFile string `mapstructure:"DISCOVERY_FILE"` // JSON/YAML file with backends to discover and watch (empty disables)
+
+	DiscoveryDNSName     string        `mapstructure:"DISCOVERY_DNS_NAME"`     // DNS name to resolve for backends (empty disables)
+	DiscoveryDNSType     string        `mapstructure:"DISCOVERY_DNS_TYPE"`     // Record type: A (A/AAAA) or SRV
+	DiscoveryDNSPort     int           `mapstructure:"DISCOVERY_DNS_PORT"`     // Backend port for A/AAAA records
+	DiscoveryDNSServer   string        `mapstructure:"DISCOVERY_DNS_SERVER"`   // Nameserver (host:port); defaults to /etc/resolv.conf
+	DiscoveryDNSInterval time.Duration `mapstructure:"DISCOVERY_DNS_INTERVAL"` // Maximum time between lookups
 }
 
+// Supported balancing strategies.
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+)
+
 // LoadConfig loads configuration from the specified path.
 // It looks for an app.env file and falls back to defaults if not found.
 // Environment variables take precedence over file values.
@@ -27,9 +113,64 @@ func LoadConfig(path string) (*Config, error) {
 	viper.AutomaticEnv()
 
 	viper.SetDefault("LISTEN_ADDRESS", ":8080")
+	viper.SetDefault("ADMIN_LISTEN_ADDRESS", "")
+	viper.SetDefault("TLS_CERT_FILE", "")
+	viper.SetDefault("TLS_KEY_FILE", "")
+	viper.SetDefault("TLS_MIN_VERSION", "1.2")
+	viper.SetDefault("TLS_CIPHER_SUITES", []string{})
+	viper.SetDefault("SERVER_HTTP2", true)
+	viper.SetDefault("SERVER_H2C", false)
 	viper.SetDefault("BACKENDS", []string{"localhost:9001", "localhost:9002"})
 	viper.SetDefault("RATE_LIMIT_CAPACITY", 5.0)
 	viper.SetDefault("RATE_LIMIT_REFILL_RATE", 1.0)
+	viper.SetDefault("RATE_LIMIT_PER_IP", false)
+	viper.SetDefault("BALANCE_STRATEGY", StrategyRoundRobin)
+	viper.SetDefault("GRPC_API_KEY_METADATA", "authorization")
+	viper.SetDefault("HEALTH_CHECK_PATH", "/health")
+	viper.SetDefault("HEALTH_CHECK_INTERVAL", 15*time.Second)
+	viper.SetDefault("HEALTH_DEGRADED_LATENCY", time.Duration(0))
+	viper.SetDefault("HEALTH_DEGRADED_STATUS_CODES", []int{})
+	viper.SetDefault("HEALTH_DEGRADED_BODY", "")
+	viper.SetDefault("CIRCUIT_BREAKER_FAILURE_RATIO", 0.0)
+	viper.SetDefault("CIRCUIT_BREAKER_MIN_REQUESTS", 20)
+	viper.SetDefault("CIRCUIT_BREAKER_WINDOW", 10*time.Second)
+	viper.SetDefault("CIRCUIT_BREAKER_OPEN_DURATION", 30*time.Second)
+	viper.SetDefault("CIRCUIT_BREAKER_HALF_OPEN_PROBES", 3)
+	viper.SetDefault("DRAIN_TIMEOUT", 30*time.Second)
+	viper.SetDefault("SERVER_READ_TIMEOUT", 15*time.Second)
+	viper.SetDefault("SERVER_READ_HEADER_TIMEOUT", 5*time.Second)
+	viper.SetDefault("SERVER_WRITE_TIMEOUT", 15*time.Second)
+	viper.SetDefault("SERVER_IDLE_TIMEOUT", 60*time.Second)
+	viper.SetDefault("BACKEND_CONNECT_TIMEOUT", 10*time.Second)
+	viper.SetDefault("BACKEND_RESPONSE_HEADER_TIMEOUT", 10*time.Second)
+	viper.SetDefault("BACKEND_IDLE_CONN_TIMEOUT", 30*time.Second)
+	viper.SetDefault("REQUEST_TIMEOUT", time.Duration(0))
+	viper.SetDefault("BACKEND_PROTOCOL", backend.ProtocolHTTP1)
+	viper.SetDefault("TUNNEL_IDLE_TIMEOUT", 5*time.Minute)
+	viper.SetDefault("STREAM_MAX_DURATION", time.Hour)
+	viper.SetDefault("BACKEND_MAX_CONNS", 0)
+	viper.SetDefault("QUEUE_SIZE", 100)
+	viper.SetDefault("QUEUE_TIMEOUT", 5*time.Second)
+	viper.SetDefault("RETRY_ATTEMPTS", 0)
+	viper.SetDefault("RETRY_STATUS_CODES", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout})
+	viper.SetDefault("RETRY_PER_TRY_TIMEOUT", time.Duration(0))
+	viper.SetDefault("RETRY_SAFE_HEADER", "X-Retry-Safe")
+	viper.SetDefault("RETRY_BUDGET_RATIO", 0.2)
+	viper.SetDefault("RETRY_BUDGET_MIN_RETRIES", 10)
+	viper.SetDefault("RETRY_BUDGET_WINDOW", 10*time.Second)
+	viper.SetDefault("REQUEST_BUFFER_MEMORY", 0)
+	viper.SetDefault("REQUEST_BUFFER_FILE_LIMIT", 0)
+	viper.SetDefault("TRUSTED_PROXIES", []string{})
+	viper.SetDefault("BACKEND_TLS_CA_FILE", "")
+	viper.SetDefault("BACKEND_TLS_SERVER_NAME", "")
+	viper.SetDefault("BACKEND_TLS_INSECURE_SKIP_VERIFY", false)
+	viper.SetDefault("CONFIG_FILE", "")
+	viper.SetDefault("DISCOVERY_FILE", "")
+	viper.SetDefault("DISCOVERY_DNS_NAME", "")
+	viper.SetDefault("DISCOVERY_DNS_TYPE", "A")
+	viper.SetDefault("DISCOVERY_DNS_PORT", 80)
+	viper.SetDefault("DISCOVERY_DNS_SERVER", "")
+	viper.SetDefault("DISCOVERY_DNS_INTERVAL", 30*time.Second)
 
 	// Try to read config file, but don't fail if it doesn't exist
 	if err := viper.ReadInConfig(); err != nil {
@@ -46,6 +187,24 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	// Viper splits lists on commas without trimming, so status code lists
+	// such as "502, 503" are parsed here
+	var err error
+	if cfg.HealthDegradedStatusCodes, err = parseStatusCodes(viper.Get("HEALTH_DEGRADED_STATUS_CODES")); err != nil {
+		return nil, fmt.Errorf("invalid HEALTH_DEGRADED_STATUS_CODES: %w", err)
+	}
+	if cfg.RetryStatusCodes, err = parseStatusCodes(viper.Get("RETRY_STATUS_CODES")); err != nil {
+		return nil, fmt.Errorf("invalid RETRY_STATUS_CODES: %w", err)
+	}
+
+	if cfg.ConfigFile != "" {
+		fileConfig, err := loadFileConfig(cfg.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.File = fileConfig
+	}
+
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -60,8 +219,28 @@ func (c *Config) Validate() error {
 		return errors.New("listen address cannot be empty")
 	}
 
-	if len(c.Backends) == 0 {
-		return errors.New("at least one backend must be configured")
+	if c.AdminListenAddress != "" && c.AdminListenAddress == c.ListenAddress {
+		return errors.New("admin listen address must differ from the listen address")
+	}
+
+	if len(c.Backends) == 0 && len(c.File.Backends) == 0 && c.DiscoveryFile == "" && c.DiscoveryDNSName == "" && len(c.File.Pools) == 0 {
+		return errors.New("at least one backend, discovery source or pool must be configured")
+	}
+
+	for _, addr := range c.Backends {
+		if _, err := backend.ParseURL(addr); err != nil {
+			return err
+		}
+	}
+
+	if err := c.File.validate(); err != nil {
+		return err
+	}
+
+	for _, p := range c.Pools()[1:] {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("pool %s: %w", p.Name, err)
+		}
 	}
 
 	if c.RateLimitCapacity <= 0 {
@@ -72,5 +251,357 @@ func (c *Config) Validate() error {
 		return errors.New("rate limit refill rate must be greater than 0")
 	}
 
+	if err := validateStrategy(c.BalanceStrategy); err != nil {
+		return err
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls cert file and key file must be set together")
+	}
+
+	if _, err := parseTLSVersion(c.TLSMinVersion); err != nil {
+		return err
+	}
+
+	for _, name := range c.TLSCipherSuites {
+		if _, err := parseCipherSuite(name); err != nil {
+			return err
+		}
+	}
+
+	defaultPool := c.Pools()[0]
+	if err := defaultPool.validateProtocol(); err != nil {
+		return err
+	}
+
+	if c.HealthCheckInterval <= 0 {
+		return errors.New("health check interval must be greater than 0")
+	}
+
+	if c.HealthDegradedLatency < 0 {
+		return errors.New("health degraded latency cannot be negative")
+	}
+
+	if err := validateStatusCodes(c.HealthDegradedStatusCodes); err != nil {
+		return err
+	}
+
+	if c.DiscoveryDNSName != "" && c.DiscoveryDNSInterval <= 0 {
+		return errors.New("dns discovery interval must be greater than 0")
+	}
+
+	if c.BreakerFailureRatio < 0 || c.BreakerFailureRatio > 1 {
+		return errors.New("circuit breaker failure ratio must be between 0 and 1")
+	}
+
+	if c.DrainTimeout <= 0 {
+		return errors.New("drain timeout must be greater than 0")
+	}
+
+	for _, timeout := range []time.Duration{
+		c.ServerReadTimeout, c.ServerReadHeaderTimeout, c.ServerWriteTimeout, c.ServerIdleTimeout,
+		c.BackendConnectTimeout, c.BackendResponseHeaderTimeout, c.BackendIdleConnTimeout, c.RequestTimeout,
+		c.TunnelIdleTimeout, c.StreamMaxDuration,
+	} {
+		if timeout < 0 {
+			return errors.New("timeouts cannot be negative")
+		}
+	}
+
+	if c.BackendMaxConns < 0 {
+		return errors.New("backend max conns cannot be negative")
+	}
+
+	if c.QueueSize < 0 {
+		return errors.New("queue size cannot be negative")
+	}
+
+	if c.QueueSize > 0 && c.QueueTimeout <= 0 {
+		return errors.New("queue timeout must be greater than 0")
+	}
+
+	if err := c.validateRetries(); err != nil {
+		return err
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			return err
+		}
+	}
+
+	if c.BreakerFailureRatio > 0 {
+		if c.BreakerMinRequests <= 0 {
+			return errors.New("circuit breaker min requests must be greater than 0")
+		}
+		if c.BreakerOpenDuration <= 0 {
+			return errors.New("circuit breaker open duration must be greater than 0")
+		}
+		if c.BreakerHalfOpenProbes <= 0 {
+			return errors.New("circuit breaker half-open probes must be greater than 0")
+		}
+	}
+
 	return nil
 }
+
+// validateRetries checks the retry policy, budget and body buffering.
+func (c *Config) validateRetries() error {
+	if c.RetryAttempts < 0 {
+		return errors.New("retry attempts cannot be negative")
+	}
+	for _, code := range c.RetryStatusCodes {
+		if code < 500 || code > 599 {
+			return fmt.Errorf("retry status code %d is not a 5xx code", code)
+		}
+	}
+	if c.RetryPerTryTimeout < 0 {
+		return errors.New("retry per-try timeout cannot be negative")
+	}
+	if c.RetryBudgetRatio < 0 || c.RetryBudgetMinRetries < 0 {
+		return errors.New("retry budget cannot be negative")
+	}
+	if c.RetryAttempts > 0 && c.RetryBudgetWindow <= 0 {
+		return errors.New("retry budget window must be greater than 0")
+	}
+	if c.RequestBufferMemory < 0 || c.RequestBufferFileLimit < 0 {
+		return errors.New("request buffer limits cannot be negative")
+	}
+	if c.RequestBufferFileLimit > 0 && c.RequestBufferFileLimit <= c.RequestBufferMemory {
+		return errors.New("request buffer file limit must exceed the memory limit")
+	}
+	return nil
+}
+
+// parseStatusCodes parses a list of HTTP status codes given as a
+// comma-separated string, as read from the environment or app.env, or as a
+// list of strings or numbers.
+func parseStatusCodes(value any) ([]int, error) {
+	var items []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		items = strings.Split(v, ",")
+	case []string:
+		for _, item := range v {
+			items = append(items, strings.Split(item, ",")...)
+		}
+	case []int:
+		return slices.Clone(v), nil
+	case []any:
+		for _, item := range v {
+			items = append(items, strings.Split(fmt.Sprint(item), ",")...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported status code list %v", value)
+	}
+
+	codes := []int{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		code, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", item)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// validateStatusCodes checks that every code is a valid HTTP status code.
+func validateStatusCodes(codes []int) error {
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("status code %d is out of range 100-599", code)
+		}
+	}
+	return nil
+}
+
+// validateStrategy checks that name is a supported balancing strategy.
+func validateStrategy(name string) error {
+	switch name {
+	case StrategyRoundRobin, StrategyWeightedRoundRobin:
+		return nil
+	default:
+		return fmt.Errorf("unknown balance strategy %q", name)
+	}
+}
+
+// Pools returns the default pool, configured by environment variables,
+// followed by the pools from the config file with empty settings inherited
+// from the default pool.
+func (c *Config) Pools() []PoolConfig {
+	base := PoolConfig{
+		Name:          pool.Default,
+		Strategy:      c.BalanceStrategy,
+		Backends:      c.StaticTargets(),
+		DiscoveryFile: c.DiscoveryFile,
+		Health: HealthConfig{
+			Path:                c.HealthCheckPath,
+			Interval:            c.HealthCheckInterval,
+			DegradedLatency:     c.HealthDegradedLatency,
+			DegradedStatusCodes: c.HealthDegradedStatusCodes,
+			DegradedBody:        c.HealthDegradedBody,
+		},
+		Transport: TransportConfig{
+			TLS:      c.BackendTLS(),
+			MaxConns: c.BackendMaxConns,
+			TransportOptions: backend.TransportOptions{
+				ConnectTimeout:        c.BackendConnectTimeout,
+				ResponseHeaderTimeout: c.BackendResponseHeaderTimeout,
+				IdleConnTimeout:       c.BackendIdleConnTimeout,
+				Protocol:              c.BackendProtocol,
+			},
+		},
+		RequestTimeout: c.RequestTimeout,
+	}
+	if c.DiscoveryDNSName != "" {
+		base.DiscoveryDNS = &discovery.DNSConfig{
+			Name:     c.DiscoveryDNSName,
+			Type:     c.DiscoveryDNSType,
+			Port:     c.DiscoveryDNSPort,
+			Server:   c.DiscoveryDNSServer,
+			Interval: c.DiscoveryDNSInterval,
+		}
+	}
+
+	pools := []PoolConfig{base}
+	for _, p := range c.File.Pools {
+		p = p.withDefaults(base)
+		if p.DiscoveryDNS != nil {
+			dns := *p.DiscoveryDNS
+			dns.Port = cmp.Or(dns.Port, c.DiscoveryDNSPort)
+			dns.Interval = cmp.Or(dns.Interval, c.DiscoveryDNSInterval)
+			p.DiscoveryDNS = &dns
+		}
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// StaticTargets returns the backends listed in BACKENDS and in the config
+// file. A backend listed in both keeps the config file's description.
+func (c *Config) StaticTargets() []discovery.Target {
+	targets := slices.Clone(c.File.Backends)
+	for _, addr := range c.Backends {
+		if !slices.ContainsFunc(targets, func(t discovery.Target) bool { return t.Addr == addr }) {
+			targets = append(targets, discovery.Target{Addr: addr})
+		}
+	}
+	return targets
+}
+
+// BreakerSettings returns the circuit breaker settings for backends,
+// or nil if circuit breaking is disabled.
+func (c *Config) BreakerSettings() *backend.BreakerSettings {
+	if c.BreakerFailureRatio == 0 {
+		return nil
+	}
+	return &backend.BreakerSettings{
+		FailureRatio:   c.BreakerFailureRatio,
+		MinRequests:    c.BreakerMinRequests,
+		Window:         c.BreakerWindow,
+		OpenDuration:   c.BreakerOpenDuration,
+		HalfOpenProbes: c.BreakerHalfOpenProbes,
+	}
+}
+
+// BackendTLS returns the default TLS settings for https backends,
+// or nil if none are configured.
+func (c *Config) BackendTLS() *backend.TLSConfig {
+	if c.BackendTLSCAFile == "" && c.BackendTLSServerName == "" && !c.BackendTLSInsecureSkipVerify {
+		return nil
+	}
+	return &backend.TLSConfig{
+		CAFile:             c.BackendTLSCAFile,
+		ServerName:         c.BackendTLSServerName,
+		InsecureSkipVerify: c.BackendTLSInsecureSkipVerify,
+	}
+}
+
+// Certificates returns the certificates served to clients: TLS_CERT_FILE
+// first, as the default for clients that match no other certificate,
+// followed by those of the config file.
+func (c *Config) Certificates() []Certificate {
+	var certs []Certificate
+	if c.TLSCertFile != "" {
+		certs = append(certs, Certificate{CertFile: c.TLSCertFile, KeyFile: c.TLSKeyFile})
+	}
+	return append(certs, c.File.TLS.Certificates...)
+}
+
+// TLSVersion returns the minimum TLS version accepted from clients.
+func (c *Config) TLSVersion() uint16 {
+	version, _ := parseTLSVersion(c.TLSMinVersion)
+	return version
+}
+
+// TLSCipherSuiteIDs returns the IDs of the configured cipher suites, or nil
+// for Go's defaults. Invalid names, which Validate rejects, are skipped.
+func (c *Config) TLSCipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, name := range c.TLSCipherSuites {
+		if id, err := parseCipherSuite(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// parseTLSVersion parses a TLS version such as 1.2.
+func parseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimSpace(s) {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls version %q", s)
+	}
+}
+
+// parseCipherSuite parses the name of a secure cipher suite, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+func parseCipherSuite(name string) (uint16, error) {
+	name = strings.TrimSpace(name)
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown or insecure cipher suite %q", name)
+}
+
+// TrustedProxyPrefixes returns the networks of trusted proxies. Invalid
+// entries, which Validate rejects, are skipped.
+func (c *Config) TrustedProxyPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parseTrustedProxy(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// parseTrustedProxy parses a CIDR, or a single address as a host prefix.
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: expected an address or CIDR", s)
+	}
+	return prefix.Masked(), nil
+}
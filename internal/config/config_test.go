@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// loadConfig loads the configuration from an app.env with the given
+// contents, starting from a clean viper state.
+func loadConfig(t *testing.T, env string) (*Config, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.env"), []byte(env), 0o600); err != nil {
+		t.Fatalf("Failed to write app.env: %v", err)
+	}
+	return LoadConfig(dir)
+}
+
+func TestLoadConfig_ResilienceOffByDefault(t *testing.T) {
+	cfg, err := loadConfig(t, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.BreakerSettings() != nil {
+		t.Errorf("Expected circuit breaking to be disabled by default, got ratio %v", cfg.BreakerFailureRatio)
+	}
+	if cfg.RetryAttempts != 0 {
+		t.Errorf("Expected retries to be disabled by default, got %d", cfg.RetryAttempts)
+	}
+}
+
+func TestParseStatusCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		expected []int
+		wantErr  bool
+	}{
+		{"nil", nil, nil, false},
+		{"empty string", "", []int{}, false},
+		{"comma separated", "502,503", []int{502, 503}, false},
+		{"spaces", " 502, 503 ,504 ", []int{502, 503, 504}, false},
+		{"string list", []string{"502", " 503"}, []int{502, 503}, false},
+		{"split string list", []string{"502, 503"}, []int{502, 503}, false},
+		{"int list", []int{429}, []int{429}, false},
+		{"yaml list", []any{502, "503"}, []int{502, 503}, false},
+		{"not a number", "502, bad", nil, true},
+		{"unsupported type", 502.5, nil, true},
+	}
+	for _, tt := range tests {
+		codes, err := parseStatusCodes(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(codes, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, codes)
+		}
+	}
+}
+
+func TestLoadConfig_StatusCodeLists(t *testing.T) {
+	cfg, err := loadConfig(t, "HEALTH_DEGRADED_STATUS_CODES=429, 503\nRETRY_STATUS_CODES=502, 503\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(cfg.HealthDegradedStatusCodes, []int{429, 503}) {
+		t.Errorf("Expected degraded status codes [429 503], got %v", cfg.HealthDegradedStatusCodes)
+	}
+	if !slices.Equal(cfg.RetryStatusCodes, []int{502, 503}) {
+		t.Errorf("Expected retry status codes [502 503], got %v", cfg.RetryStatusCodes)
+	}
+
+	t.Setenv("RETRY_STATUS_CODES", " 504 ")
+	cfg, err = loadConfig(t, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(cfg.RetryStatusCodes, []int{504}) {
+		t.Errorf("Expected the environment to override the retry status codes, got %v", cfg.RetryStatusCodes)
+	}
+}
+
+func TestLoadConfig_StatusCodeErrors(t *testing.T) {
+	tests := []struct {
+		env      string
+		expected string
+	}{
+		{"HEALTH_DEGRADED_STATUS_CODES=503,abc", "invalid HEALTH_DEGRADED_STATUS_CODES"},
+		{"HEALTH_DEGRADED_STATUS_CODES=99", "out of range"},
+		{"HEALTH_DEGRADED_STATUS_CODES=600", "out of range"},
+		{"RETRY_STATUS_CODES=502;503", "invalid RETRY_STATUS_CODES"},
+		{"RETRY_STATUS_CODES=404", "not a 5xx code"},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(t, tt.env)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.env, tt.expected, err)
+		}
+	}
+}
+
+func TestLoadConfig_PoolDegradedStatusCodes(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	yaml := "pools:\n  - name: api\n    backends:\n      - address: localhost:9001\n    health:\n      degraded_status_codes: [429, 700]\n"
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	_, err := loadConfig(t, "CONFIG_FILE="+file)
+	if err == nil || !strings.Contains(err.Error(), "pool api: status code 700 is out of range") {
+		t.Errorf("Expected the pool's status codes to be range-checked, got %v", err)
+	}
+}
+
+func TestLoadConfig_H2CTargets(t *testing.T) {
+	tests := []struct {
+		env     string
+		wantErr bool
+	}{
+		{"BACKEND_PROTOCOL=h2c\nBACKENDS=localhost:9001,unix:///run/app.sock", false},
+		{"BACKEND_PROTOCOL=h2c\nBACKENDS=https://localhost:9001", true},
+		{"BACKEND_PROTOCOL=http2\nBACKENDS=https://localhost:9001", false},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(t, tt.env)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error: %v", tt.env, err)
+		}
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	yaml := "pools:\n  - name: grpc\n    backends:\n      - address: https://localhost:9001\n    transport:\n      protocol: h2c\n"
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	_, err := loadConfig(t, "CONFIG_FILE="+file)
+	if err == nil || !strings.Contains(err.Error(), "pool grpc: backend https://localhost:9001: h2c cannot be used with https") {
+		t.Errorf("Expected the pool's https backend to be rejected for h2c, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig holds structured settings that do not fit into environment
+// variables. It is read from the YAML file named by CONFIG_FILE.
+type FileConfig struct {
+	Backends []discovery.Target `yaml:"backends"`  // Static backends of the default pool, with weights and tags
+	TagRules []TagRule          `yaml:"tag_rules"` // Rules restricting requests to tagged backends
+	Pools    []PoolConfig       `yaml:"pools"`     // Named backend pools in addition to the default one
+	Routes   []router.Route     `yaml:"routes"`    // Routing table selecting the pool for each request
+	TLS      ListenerTLSConfig  `yaml:"tls"`       // Certificates served to clients in addition to TLS_CERT_FILE
+}
+
+// ListenerTLSConfig lists certificates served to clients. The certificate
+// is selected by the server name the client asks for (SNI).
+type ListenerTLSConfig struct {
+	Certificates []Certificate `yaml:"certificates"`
+}
+
+// Certificate names the PEM files of a certificate and its private key.
+type Certificate struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// PoolConfig describes a named backend pool. Settings left empty are
+// inherited from the default pool, which is configured by environment
+// variables.
+type PoolConfig struct {
+	Name          string               `yaml:"name"`
+	Strategy      string               `yaml:"strategy"`       // Balancing strategy: round_robin or weighted_round_robin
+	Backends      []discovery.Target   `yaml:"backends"`       // Static backends
+	DiscoveryFile string               `yaml:"discovery_file"` // JSON/YAML file with backends to watch
+	DiscoveryDNS  *discovery.DNSConfig `yaml:"discovery_dns"`  // DNS name to resolve for backends
+	Health        HealthConfig         `yaml:"health"`
+	Transport     TransportConfig      `yaml:"transport"`
+
+	RequestTimeout time.Duration `yaml:"request_timeout"` // Total time allowed for a request, including retries (0 disables)
+}
+
+// HealthConfig describes the health checks of a pool.
+type HealthConfig struct {
+	Path                string        `yaml:"path"`                  // Health endpoint path
+	Interval            time.Duration `yaml:"interval"`              // Time between checks
+	DegradedLatency     time.Duration `yaml:"degraded_latency"`      // Probe latency above which a backend is degraded
+	DegradedStatusCodes []int         `yaml:"degraded_status_codes"` // Probe status codes that mark a backend degraded
+	DegradedBody        string        `yaml:"degraded_body"`         // Probe body substring that marks a backend degraded
+}
+
+// TransportConfig describes how a pool connects to its backends.
+type TransportConfig struct {
+	TLS                      *backend.TLSConfig `yaml:"tls"`       // TLS settings for https backends without their own
+	MaxConns                 int                `yaml:"max_conns"` // Concurrent request limit for backends without their own
+	backend.TransportOptions `yaml:",inline"`
+}
+
+// TagRule restricts matching requests to backends carrying all of Tags.
+// A request matches when its Header equals Value; an empty Value matches
+// any non-empty header, and an empty Header matches every request.
+// Rules are evaluated in order and the first match applies.
+type TagRule struct {
+	Header string            `yaml:"header"`
+	Value  string            `yaml:"value"`
+	Tags   map[string]string `yaml:"tags"`
+}
+
+// loadFileConfig reads and strictly decodes the YAML config file at path.
+func loadFileConfig(path string) (FileConfig, error) {
+	var fc FileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fc, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fc, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return fc, nil
+}
+
+// validate checks the structured settings.
+func (fc *FileConfig) validate() error {
+	if err := discovery.ValidateTargets(fc.Backends); err != nil {
+		return err
+	}
+	for i, cert := range fc.TLS.Certificates {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			return fmt.Errorf("tls certificate %d needs both cert_file and key_file", i+1)
+		}
+	}
+	for i, rule := range fc.TagRules {
+		if len(rule.Tags) == 0 {
+			return fmt.Errorf("tag rule %d has no tags", i+1)
+		}
+	}
+
+	seen := map[string]bool{pool.Default: true}
+	for _, p := range fc.Pools {
+		if p.Name == "" {
+			return errors.New("pool name cannot be empty")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("pool name %q is reserved or used more than once", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	if _, err := router.New(fc.Routes); err != nil {
+		return err
+	}
+	for _, route := range fc.Routes {
+		if !seen[route.Pool] {
+			return fmt.Errorf("route targets unknown pool %q", route.Pool)
+		}
+	}
+	return nil
+}
+
+// validate checks the settings of a pool after defaults have been applied.
+func (p *PoolConfig) validate() error {
+	if len(p.Backends) == 0 && p.DiscoveryFile == "" && p.DiscoveryDNS == nil {
+		return errors.New("no backends or discovery source configured")
+	}
+	if err := validateStrategy(p.Strategy); err != nil {
+		return err
+	}
+	if err := discovery.ValidateTargets(p.Backends); err != nil {
+		return err
+	}
+	if p.Health.Interval <= 0 {
+		return errors.New("health check interval must be greater than 0")
+	}
+	if p.Health.DegradedLatency < 0 {
+		return errors.New("health degraded latency cannot be negative")
+	}
+	if err := validateStatusCodes(p.Health.DegradedStatusCodes); err != nil {
+		return err
+	}
+	if p.Transport.MaxConns < 0 {
+		return errors.New("max conns cannot be negative")
+	}
+	if err := p.validateProtocol(); err != nil {
+		return err
+	}
+	if p.Transport.ConnectTimeout < 0 || p.Transport.ResponseHeaderTimeout < 0 || p.Transport.IdleConnTimeout < 0 || p.RequestTimeout < 0 {
+		return errors.New("timeouts cannot be negative")
+	}
+	return nil
+}
+
+// validateProtocol checks the pool's backend protocol and that its static
+// backends can be reached with it.
+func (p *PoolConfig) validateProtocol() error {
+	if err := backend.ValidateProtocol(p.Transport.Protocol); err != nil {
+		return err
+	}
+	for _, target := range p.Backends {
+		if err := backend.ValidateTargetProtocol(target.Addr, p.Transport.Protocol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withDefaults fills in settings the pool leaves empty from base.
+func (p PoolConfig) withDefaults(base PoolConfig) PoolConfig {
+	p.Strategy = cmp.Or(p.Strategy, base.Strategy)
+	p.Health.Path = cmp.Or(p.Health.Path, base.Health.Path)
+	p.Health.Interval = cmp.Or(p.Health.Interval, base.Health.Interval)
+	p.Health.DegradedLatency = cmp.Or(p.Health.DegradedLatency, base.Health.DegradedLatency)
+	if p.Health.DegradedStatusCodes == nil {
+		p.Health.DegradedStatusCodes = base.Health.DegradedStatusCodes
+	}
+	p.Health.DegradedBody = cmp.Or(p.Health.DegradedBody, base.Health.DegradedBody)
+	p.Transport.TLS = cmp.Or(p.Transport.TLS, base.Transport.TLS)
+	p.Transport.MaxConns = cmp.Or(p.Transport.MaxConns, base.Transport.MaxConns)
+	p.Transport.ConnectTimeout = cmp.Or(p.Transport.ConnectTimeout, base.Transport.ConnectTimeout)
+	p.Transport.ResponseHeaderTimeout = cmp.Or(p.Transport.ResponseHeaderTimeout, base.Transport.ResponseHeaderTimeout)
+	p.Transport.IdleConnTimeout = cmp.Or(p.Transport.IdleConnTimeout, base.Transport.IdleConnTimeout)
+	p.Transport.Protocol = cmp.Or(p.Transport.Protocol, base.Transport.Protocol)
+	p.RequestTimeout = cmp.Or(p.RequestTimeout, base.RequestTimeout)
+	return p
+}
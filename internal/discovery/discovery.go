@@ -0,0 +1,183 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event is an update emitted by a Discovery provider. A full event replaces
+// the provider's whole target set with Targets; otherwise Added and Removed
+// describe changes relative to the previous state.
+type Event struct {
+	Full    bool     // True if Targets is a complete snapshot
+	Targets []Target // Complete target set (full events only)
+	Added   []Target // Targets added or changed (delta events only)
+	Removed []string // Addresses of removed targets (delta events only)
+}
+
+// Snapshot returns a full event with the given targets.
+func Snapshot(targets []Target) Event {
+	return Event{Full: true, Targets: targets}
+}
+
+// Discovery is a source of backend targets.
+type Discovery interface {
+	// Name identifies the provider in logs.
+	Name() string
+	// Run emits events until the context is cancelled. A provider should
+	// emit a full snapshot first and may follow up with snapshots or deltas.
+	Run(ctx context.Context, events chan<- Event) error
+}
+
+// send delivers an event unless the context is cancelled first.
+func send(ctx context.Context, events chan<- Event, event Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// targetSet is the current state of a provider, keyed by address.
+type targetSet map[string]Target
+
+// apply updates the set with an event.
+func (s targetSet) apply(event Event) {
+	if event.Full {
+		clear(s)
+		for _, t := range event.Targets {
+			s[t.Addr] = t
+		}
+		return
+	}
+	for _, addr := range event.Removed {
+		delete(s, addr)
+	}
+	for _, t := range event.Added {
+		s[t.Addr] = t
+	}
+}
+
+// StaticProvider announces a fixed list of backends.
+type StaticProvider struct {
+	targets []Target
+}
+
+// NewStaticProvider creates a provider for a fixed list of targets.
+func NewStaticProvider(targets []Target) *StaticProvider {
+	return &StaticProvider{targets: targets}
+}
+
+func (p *StaticProvider) Name() string {
+	return "static"
+}
+
+func (p *StaticProvider) Run(ctx context.Context, events chan<- Event) error {
+	send(ctx, events, Snapshot(p.targets))
+	<-ctx.Done()
+	return nil
+}
+
+// Composite merges several providers into one. It keeps the latest state
+// of every provider and emits a de-duplicated snapshot whenever the merged
+// set changes. When providers announce the same address, the one listed
+// first wins.
+type Composite struct {
+	providers []Discovery
+}
+
+// Compose creates a provider merging the given providers in priority order.
+func Compose(providers ...Discovery) *Composite {
+	return &Composite{providers: providers}
+}
+
+func (c *Composite) Name() string {
+	names := make([]string, 0, len(c.providers))
+	for _, p := range c.providers {
+		names = append(names, p.Name())
+	}
+	return fmt.Sprintf("composite(%s)", strings.Join(names, ","))
+}
+
+// providerEvent tags an event with the index of the provider that sent it.
+type providerEvent struct {
+	index int
+	event Event
+}
+
+func (c *Composite) Run(ctx context.Context, events chan<- Event) error {
+	updates := make(chan providerEvent)
+	for i, p := range c.providers {
+		go func() {
+			forward := make(chan Event)
+			go func() {
+				for event := range forward {
+					select {
+					case updates <- providerEvent{index: i, event: event}:
+					case <-ctx.Done():
+					}
+				}
+			}()
+			if err := p.Run(ctx, forward); err != nil {
+				log.Error().Err(err).Str("provider", p.Name()).Msg("Discovery provider stopped")
+			}
+			close(forward)
+		}()
+	}
+
+	sets := make([]targetSet, len(c.providers))
+	for i := range sets {
+		sets[i] = make(targetSet)
+	}
+
+	var previous []Target
+	first := true
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update := <-updates:
+			sets[update.index].apply(update.event)
+			merged := c.merge(sets)
+			if !first && slices.EqualFunc(merged, previous, Target.equal) {
+				continue
+			}
+			if !send(ctx, events, Snapshot(merged)) {
+				return nil
+			}
+			previous, first = merged, false
+		}
+	}
+}
+
+// merge combines provider sets into a snapshot sorted by address,
+// keeping the first provider's target for duplicate addresses.
+func (c *Composite) merge(sets []targetSet) []Target {
+	merged := make(map[string]Target)
+	for i, set := range sets {
+		for addr, t := range set {
+			if _, exists := merged[addr]; exists {
+				log.Debug().
+					Str("backend", addr).
+					Str("provider", c.providers[i].Name()).
+					Msg("Ignoring duplicate discovered backend")
+				continue
+			}
+			merged[addr] = t
+		}
+	}
+
+	targets := make([]Target, 0, len(merged))
+	for _, t := range merged {
+		targets = append(targets, t)
+	}
+	slices.SortFunc(targets, func(a, b Target) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+	return targets
+}
@@ -0,0 +1,244 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"load-balancer/internal/backend"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRegistry is an in-memory Registry for tests.
+type fakeRegistry struct {
+	mu       sync.Mutex
+	backends map[string]*backend.Backend
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{backends: make(map[string]*backend.Backend)}
+}
+
+func (f *fakeRegistry) AddBackend(b *backend.Backend) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.backends[b.Addr]; ok {
+		return errors.New("exists")
+	}
+	f.backends[b.Addr] = b
+	return nil
+}
+
+func (f *fakeRegistry) RemoveBackend(addr string) (*backend.Backend, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.backends[addr]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	delete(f.backends, addr)
+	return b, nil
+}
+
+func (f *fakeRegistry) GetBackend(addr string) (*backend.Backend, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.backends[addr]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return b, nil
+}
+
+func TestReconciler_Apply(t *testing.T) {
+	registry := newFakeRegistry()
+	static := &backend.Backend{Addr: "static:80"}
+	registry.AddBackend(static)
+
+	r := NewReconciler(registry, BackendDefaults{})
+	r.Apply([]Target{
+		{Addr: "a:80", Weight: 1},
+		{Addr: "b:80", Weight: 1},
+	})
+	a, _ := registry.GetBackend("a:80")
+	a.SetState(backend.StateHealthy)
+	b, _ := registry.GetBackend("b:80")
+	b.SetState(backend.StateHealthy)
+
+	r.Apply([]Target{
+		{Addr: "a:80", Weight: 1},
+		{Addr: "b:80", Weight: 5},
+		{Addr: "c:80"},
+	})
+
+	if got, _ := registry.GetBackend("a:80"); got != a {
+		t.Error("Unchanged backend should be kept")
+	}
+	got, err := registry.GetBackend("b:80")
+	if err != nil || got == b || got.Weight != 5 {
+		t.Fatal("Changed backend should be replaced")
+	}
+	if !got.IsHealthy() {
+		t.Error("Replaced backend should keep its health state")
+	}
+	if _, err := registry.GetBackend("c:80"); err != nil {
+		t.Error("New backend should be added")
+	}
+
+	r.Apply(nil)
+	if len(registry.backends) != 1 || registry.backends["static:80"] != static {
+		t.Errorf("Only the static backend should remain, got %v", registry.backends)
+	}
+}
+
+func TestReconciler_RejectsTargetsUnreachableWithProtocol(t *testing.T) {
+	registry := newFakeRegistry()
+	r := NewReconciler(registry, BackendDefaults{Transport: backend.TransportOptions{Protocol: backend.ProtocolH2C}})
+	r.Apply([]Target{
+		{Addr: "a:80"},
+		{Addr: "unix:///run/app.sock"},
+		{Addr: "https://b:443"},
+	})
+
+	if len(registry.backends) != 2 || registry.backends["https://b:443"] != nil {
+		t.Errorf("Expected the https target of an h2c pool to be rejected, got %v", registry.backends)
+	}
+}
+
+func TestFileProvider_Load(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "backends.yaml")
+	os.WriteFile(yamlPath, []byte(`
+backends:
+  - address: localhost:9001
+    weight: 2
+    zone: eu-1
+    tags:
+      version: v2
+  - address: localhost:9002
+`), 0o644)
+
+	targets, err := NewFileProvider(yamlPath).Load()
+	if err != nil {
+		t.Fatalf("Failed to load YAML: %v", err)
+	}
+	if len(targets) != 2 || targets[0].Weight != 2 || targets[0].Zone != "eu-1" || targets[0].Tags["version"] != "v2" {
+		t.Errorf("Unexpected targets: %+v", targets)
+	}
+
+	jsonPath := filepath.Join(dir, "backends.json")
+	os.WriteFile(jsonPath, []byte(`{"backends": [{"address": "localhost:9001"}, {"address": "localhost:9001"}]}`), 0o644)
+	if _, err := NewFileProvider(jsonPath).Load(); err == nil {
+		t.Error("Expected error for duplicate backend")
+	}
+}
+
+func TestFileProvider_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	os.WriteFile(path, []byte(`{"backends": [{"address": "localhost:9001"}]}`), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan []Target, 1)
+	go NewFileProvider(path).Watch(ctx, func(targets []Target) {
+		updates <- targets
+	})
+	time.Sleep(50 * time.Millisecond) // Let the watcher start
+
+	// Replace the file atomically, as deployment tools do
+	tmp := path + ".tmp"
+	os.WriteFile(tmp, []byte(`{"backends": [{"address": "localhost:9001"}, {"address": "localhost:9002"}]}`), 0o644)
+	os.Rename(tmp, path)
+
+	select {
+	case targets := <-updates:
+		if len(targets) != 2 {
+			t.Errorf("Expected 2 targets, got %d", len(targets))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for file change")
+	}
+}
+
+// chanProvider is a Discovery that forwards events sent by the test.
+type chanProvider struct {
+	name   string
+	events chan Event
+}
+
+func (p *chanProvider) Name() string { return p.name }
+
+func (p *chanProvider) Run(ctx context.Context, events chan<- Event) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-p.events:
+			if !send(ctx, events, event) {
+				return nil
+			}
+		}
+	}
+}
+
+func TestComposite_DeduplicatesAndAppliesDeltas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dynamic := &chanProvider{name: "dynamic", events: make(chan Event)}
+	composite := Compose(NewStaticProvider([]Target{{Addr: "a:80"}, {Addr: "b:80"}}), dynamic)
+
+	events := make(chan Event)
+	go composite.Run(ctx, events)
+
+	next := func() []Target {
+		select {
+		case event := <-events:
+			return event.Targets
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for snapshot")
+			return nil
+		}
+	}
+
+	if targets := next(); len(targets) != 2 {
+		t.Fatalf("Expected static snapshot with 2 targets, got %+v", targets)
+	}
+
+	dynamic.events <- Snapshot([]Target{{Addr: "b:80", Weight: 9}, {Addr: "c:80"}})
+	targets := next()
+	if len(targets) != 3 {
+		t.Fatalf("Expected 3 de-duplicated targets, got %+v", targets)
+	}
+	if targets[1].Addr != "b:80" || targets[1].Weight != 0 {
+		t.Errorf("Expected the first provider to win for b:80, got %+v", targets[1])
+	}
+
+	dynamic.events <- Event{Removed: []string{"c:80"}, Added: []Target{{Addr: "d:80"}}}
+	targets = next()
+	if len(targets) != 3 || targets[2].Addr != "d:80" {
+		t.Errorf("Expected delta to replace c:80 with d:80, got %+v", targets)
+	}
+}
+
+func TestReconciler_HandleDelta(t *testing.T) {
+	registry := newFakeRegistry()
+	r := NewReconciler(registry, BackendDefaults{})
+
+	r.Handle(Snapshot([]Target{{Addr: "a:80"}}))
+	select {
+	case <-r.Synced():
+	default:
+		t.Error("Reconciler should be synced after the first event")
+	}
+
+	r.Handle(Event{Added: []Target{{Addr: "b:80"}}})
+	r.Handle(Event{Removed: []string{"a:80"}})
+
+	if len(registry.backends) != 1 || registry.backends["b:80"] == nil {
+		t.Errorf("Expected only b:80 to be registered, got %v", registry.backends)
+	}
+}
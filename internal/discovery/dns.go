@@ -0,0 +1,298 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Supported DNS record types for discovery.
+const (
+	DNSRecordA   = "A"   // A and AAAA records, combined with a configured port
+	DNSRecordSRV = "SRV" // SRV records providing port, priority and weight
+)
+
+const (
+	// dnsMinRefresh bounds how often a name is re-resolved when records
+	// have very short TTLs.
+	dnsMinRefresh = time.Second
+	// dnsRetryInterval is the delay before retrying a failed lookup.
+	dnsRetryInterval = 5 * time.Second
+	// dnsTimeout limits a single DNS exchange.
+	dnsTimeout = 5 * time.Second
+	// resolvConfPath is where the default nameserver is read from.
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// DNSConfig configures a DNSProvider.
+type DNSConfig struct {
+	Name     string        `yaml:"name"`     // Name to resolve, e.g. api.service.internal or _http._tcp.api.internal
+	Type     string        `yaml:"type"`     // Record type: DNSRecordA (default) or DNSRecordSRV
+	Port     int           `yaml:"port"`     // Port for A/AAAA results
+	Server   string        `yaml:"server"`   // Nameserver address (host:port); defaults to the first one in /etc/resolv.conf
+	Interval time.Duration `yaml:"interval"` // Maximum time between lookups; record TTLs may shorten it
+}
+
+// DNSProvider discovers backends by periodically resolving A/AAAA or SRV
+// records. Lookups are repeated when the shortest record TTL expires,
+// bounded by the configured interval.
+type DNSProvider struct {
+	cfg DNSConfig
+}
+
+// NewDNSProvider creates a DNS discovery provider.
+func NewDNSProvider(cfg DNSConfig) (*DNSProvider, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("dns discovery name cannot be empty")
+	}
+	if cfg.Type == "" {
+		cfg.Type = DNSRecordA
+	}
+	cfg.Type = strings.ToUpper(cfg.Type)
+	switch cfg.Type {
+	case DNSRecordA:
+		if cfg.Port <= 0 || cfg.Port > 65535 {
+			return nil, errors.New("dns discovery port must be between 1 and 65535 for A records")
+		}
+	case DNSRecordSRV:
+	default:
+		return nil, fmt.Errorf("unsupported dns record type %q", cfg.Type)
+	}
+	if cfg.Interval <= 0 {
+		return nil, errors.New("dns discovery interval must be greater than 0")
+	}
+	if cfg.Server == "" {
+		server, err := defaultNameserver()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Server = server
+	}
+	return &DNSProvider{cfg: cfg}, nil
+}
+
+// Load resolves the configured name once and returns the targets.
+func (p *DNSProvider) Load() ([]Target, error) {
+	targets, _, err := p.resolve(context.Background())
+	return targets, err
+}
+
+func (p *DNSProvider) Name() string {
+	return "dns:" + p.cfg.Name
+}
+
+// Run emits a snapshot after the first successful lookup and whenever the
+// resolved records change.
+func (p *DNSProvider) Run(ctx context.Context, events chan<- Event) error {
+	return p.Watch(ctx, func(targets []Target) {
+		send(ctx, events, Snapshot(targets))
+	})
+}
+
+// Watch re-resolves the name as record TTLs expire and passes each changed
+// snapshot to onChange. Failed lookups are logged and retried, keeping the
+// last good snapshot in effect. Watch blocks until the context is cancelled.
+func (p *DNSProvider) Watch(ctx context.Context, onChange func([]Target)) error {
+	var previous []Target
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		targets, ttl, err := p.resolve(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Error().Err(err).Str("name", p.cfg.Name).Msg("DNS discovery lookup failed, keeping previous backends")
+			timer.Reset(min(dnsRetryInterval, p.cfg.Interval))
+			continue
+		}
+
+		if !slices.EqualFunc(targets, previous, Target.equal) {
+			log.Info().
+				Str("name", p.cfg.Name).
+				Int("backends", len(targets)).
+				Dur("ttl", ttl).
+				Msg("DNS discovery backends changed")
+			onChange(targets)
+			previous = targets
+		}
+		timer.Reset(min(max(ttl, dnsMinRefresh), p.cfg.Interval))
+	}
+}
+
+// resolve performs the lookups for the configured record type and returns
+// the targets sorted by address together with the shortest record TTL.
+func (p *DNSProvider) resolve(ctx context.Context) ([]Target, time.Duration, error) {
+	var targets []Target
+	ttl := p.cfg.Interval
+
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	if p.cfg.Type == DNSRecordSRV {
+		types = []dnsmessage.Type{dnsmessage.TypeSRV}
+	}
+
+	for _, qtype := range types {
+		answers, err := p.query(ctx, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, rr := range answers {
+			var target Target
+			switch body := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				target.Addr = net.JoinHostPort(net.IP(body.A[:]).String(), strconv.Itoa(p.cfg.Port))
+			case *dnsmessage.AAAAResource:
+				target.Addr = net.JoinHostPort(net.IP(body.AAAA[:]).String(), strconv.Itoa(p.cfg.Port))
+			case *dnsmessage.SRVResource:
+				host := strings.TrimSuffix(body.Target.String(), ".")
+				target.Addr = net.JoinHostPort(host, strconv.Itoa(int(body.Port)))
+				target.Weight = max(int(body.Weight), 1)
+				target.Priority = int(body.Priority)
+			default:
+				continue // CNAMEs and other records in the chain
+			}
+			targets = append(targets, target)
+			ttl = min(ttl, time.Duration(rr.Header.TTL)*time.Second)
+		}
+	}
+
+	slices.SortFunc(targets, func(a, b Target) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+	targets = slices.CompactFunc(targets, func(a, b Target) bool {
+		return a.Addr == b.Addr
+	})
+	return targets, ttl, nil
+}
+
+// query sends a single question to the nameserver and returns the answer
+// records. Truncated UDP responses are retried over TCP. A name that does
+// not exist is reported as an error so the previous snapshot is kept.
+func (p *DNSProvider) query(ctx context.Context, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	fqdn := p.cfg.Name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns name %q: %w", p.cfg.Name, err)
+	}
+
+	id := uint16(rand.Uint32())
+	request := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack dns query: %w", err)
+	}
+
+	response, err := p.exchange(ctx, "udp", packed)
+	if err == nil && response.Header.Truncated {
+		response, err = p.exchange(ctx, "tcp", packed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dns %s query for %s failed: %w", qtype, p.cfg.Name, err)
+	}
+
+	if response.Header.ID != id {
+		return nil, fmt.Errorf("dns %s query for %s: mismatched response id", qtype, p.cfg.Name)
+	}
+	if response.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("dns %s query for %s: %s", qtype, p.cfg.Name, response.Header.RCode)
+	}
+	return response.Answers, nil
+}
+
+// exchange sends a packed DNS message over the given network and returns
+// the parsed response.
+func (p *DNSProvider) exchange(ctx context.Context, network string, packed []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, p.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		// TCP messages are prefixed with a two-byte length
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(packed)))); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("failed to parse dns response: %w", err)
+	}
+	return &response, nil
+}
+
+// defaultNameserver returns the first nameserver from /etc/resolv.conf.
+func defaultNameserver() (string, error) {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read nameserver from %s: %w", resolvConfPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver found in %s", resolvConfPath)
+}
@@ -0,0 +1,195 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDNSServer is an in-process UDP nameserver answering from a record table.
+type testDNSServer struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[dnsmessage.Type][]dnsmessage.Resource
+}
+
+func startTestDNSServer(t *testing.T) *testDNSServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &testDNSServer{conn: conn, records: make(map[dnsmessage.Type][]dnsmessage.Resource)}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *testDNSServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *testDNSServer) set(qtype dnsmessage.Type, records ...dnsmessage.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[qtype] = records
+}
+
+func (s *testDNSServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var request dnsmessage.Message
+		if err := request.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		question := request.Questions[0]
+
+		s.mu.Lock()
+		answers := s.records[question.Type]
+		s.mu.Unlock()
+
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: request.Header.ID, Response: true, Authoritative: true},
+			Questions: request.Questions,
+		}
+		for _, rr := range answers {
+			rr.Header.Name = question.Name
+			rr.Header.Class = dnsmessage.ClassINET
+			response.Answers = append(response.Answers, rr)
+		}
+		packed, err := response.Pack()
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(packed, addr)
+	}
+}
+
+func aRecord(ip string, ttl uint32) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: ttl},
+		Body:   &dnsmessage.AResource{A: a},
+	}
+}
+
+func TestDNSProvider_ARecords(t *testing.T) {
+	server := startTestDNSServer(t)
+	server.set(dnsmessage.TypeA, aRecord("10.0.0.2", 30), aRecord("10.0.0.1", 30))
+	var aaaa [16]byte
+	copy(aaaa[:], net.ParseIP("fd00::1"))
+	server.set(dnsmessage.TypeAAAA, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA, TTL: 30},
+		Body:   &dnsmessage.AAAAResource{AAAA: aaaa},
+	})
+
+	provider, err := NewDNSProvider(DNSConfig{
+		Name:     "api.service.internal",
+		Port:     8080,
+		Server:   server.addr(),
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	targets, err := provider.Load()
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	expected := []string{"10.0.0.1:8080", "10.0.0.2:8080", "[fd00::1]:8080"}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %v, got %+v", expected, targets)
+	}
+	for i, addr := range expected {
+		if targets[i].Addr != addr {
+			t.Errorf("Target %d: expected %s, got %s", i, addr, targets[i].Addr)
+		}
+	}
+}
+
+func TestDNSProvider_SRVRecords(t *testing.T) {
+	server := startTestDNSServer(t)
+	target := dnsmessage.MustNewName("app1.internal.")
+	backup := dnsmessage.MustNewName("app2.internal.")
+	server.set(dnsmessage.TypeSRV,
+		dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeSRV, TTL: 30},
+			Body:   &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: 9001, Target: target},
+		},
+		dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeSRV, TTL: 30},
+			Body:   &dnsmessage.SRVResource{Priority: 20, Weight: 0, Port: 9002, Target: backup},
+		},
+	)
+
+	provider, err := NewDNSProvider(DNSConfig{
+		Name:     "_http._tcp.app.internal",
+		Type:     DNSRecordSRV,
+		Server:   server.addr(),
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	targets, err := provider.Load()
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %+v", targets)
+	}
+	if targets[0].Addr != "app1.internal:9001" || targets[0].Weight != 5 || targets[0].Priority != 10 {
+		t.Errorf("Unexpected primary target: %+v", targets[0])
+	}
+	if targets[1].Addr != "app2.internal:9002" || targets[1].Weight != 1 || targets[1].Priority != 20 {
+		t.Errorf("Unexpected backup target: %+v", targets[1])
+	}
+}
+
+func TestDNSProvider_WatchRespectsTTL(t *testing.T) {
+	server := startTestDNSServer(t)
+	server.set(dnsmessage.TypeA, aRecord("10.0.0.1", 1))
+
+	provider, err := NewDNSProvider(DNSConfig{
+		Name:     "api.service.internal",
+		Port:     80,
+		Server:   server.addr(),
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan []Target, 2)
+	go provider.Watch(ctx, func(targets []Target) {
+		updates <- targets
+	})
+
+	first := <-updates
+	if len(first) != 1 || first[0].Addr != "10.0.0.1:80" {
+		t.Fatalf("Unexpected initial targets: %+v", first)
+	}
+
+	server.set(dnsmessage.TypeA, aRecord("10.0.0.1", 1), aRecord("10.0.0.2", 1))
+	select {
+	case second := <-updates:
+		if len(second) != 2 {
+			t.Errorf("Expected 2 targets after TTL expiry, got %+v", second)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Records were not refreshed after TTL expiry")
+	}
+}
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"load-balancer/internal/backend"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// fileDebounce delays reloading after a change so that a file being
+// rewritten in several steps is read only once it is complete.
+const fileDebounce = 200 * time.Millisecond
+
+// fileContents is the layout of a discovery file.
+type fileContents struct {
+	Backends []Target `json:"backends" yaml:"backends"`
+}
+
+// FileProvider discovers backends from a JSON or YAML file and watches it
+// for changes. The format is chosen by file extension (.json, .yaml, .yml).
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a provider reading backends from path.
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+// Load reads and validates the current list of targets from the file.
+func (p *FileProvider) Load() ([]Target, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read discovery file: %w", err)
+	}
+
+	var contents fileContents
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".json":
+		err = json.Unmarshal(data, &contents)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &contents)
+	default:
+		return nil, fmt.Errorf("unsupported discovery file format %q", filepath.Ext(p.path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse discovery file: %w", err)
+	}
+
+	if err := ValidateTargets(contents.Backends); err != nil {
+		return nil, fmt.Errorf("invalid discovery file: %w", err)
+	}
+	return contents.Backends, nil
+}
+
+// ValidateTargets checks that every target has a valid, unique address
+// and non-negative limits.
+func ValidateTargets(targets []Target) error {
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if t.Addr == "" {
+			return errors.New("backend without address")
+		}
+		if _, err := backend.ParseURL(t.Addr); err != nil {
+			return err
+		}
+		if t.Weight < 0 {
+			return fmt.Errorf("backend %s has negative weight", t.Addr)
+		}
+		if t.MaxConns < 0 {
+			return fmt.Errorf("backend %s has negative max_conns", t.Addr)
+		}
+		if seen[t.Addr] {
+			return fmt.Errorf("backend %s is listed more than once", t.Addr)
+		}
+		seen[t.Addr] = true
+	}
+	return nil
+}
+
+func (p *FileProvider) Name() string {
+	return "file:" + p.path
+}
+
+// Run emits the current file contents as a snapshot and then a new
+// snapshot on every change.
+func (p *FileProvider) Run(ctx context.Context, events chan<- Event) error {
+	targets, err := p.Load()
+	if err != nil {
+		return err
+	}
+	if !send(ctx, events, Snapshot(targets)) {
+		return nil
+	}
+	return p.Watch(ctx, func(targets []Target) {
+		send(ctx, events, Snapshot(targets))
+	})
+}
+
+// Watch reloads the file whenever it changes and passes each successfully
+// parsed snapshot to onChange. Invalid contents are logged and ignored, so
+// the last good snapshot stays in effect. Watch blocks until the context is
+// cancelled.
+//
+// The parent directory is watched rather than the file itself, so that
+// atomic replacements (write to a temp file, then rename) are detected.
+func (p *FileProvider) Watch(ctx context.Context, onChange func([]Target)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(p.path)); err != nil {
+		return fmt.Errorf("failed to watch discovery file: %w", err)
+	}
+
+	target := filepath.Clean(p.path)
+	reload := time.NewTimer(fileDebounce)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != target {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+				reload.Reset(fileDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Str("path", p.path).Msg("Discovery file watcher error")
+		case <-reload.C:
+			targets, err := p.Load()
+			if err != nil {
+				log.Error().Err(err).Str("path", p.path).Msg("Failed to reload discovery file, keeping previous backends")
+				continue
+			}
+			log.Info().Str("path", p.path).Int("backends", len(targets)).Msg("Discovery file reloaded")
+			onChange(targets)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package discovery keeps the balancer's backend set in sync with external
+// sources of backend addresses.
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"load-balancer/internal/backend"
+	"maps"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Target describes a backend announced by a discovery source.
+type Target struct {
+	Addr     string             `json:"address" yaml:"address"`     // Address of the backend server (host:port)
+	Weight   int                `json:"weight" yaml:"weight"`       // Relative weight (0 means 1)
+	Priority int                `json:"priority" yaml:"priority"`   // Failover group, lower is preferred
+	MaxConns int                `json:"max_conns" yaml:"max_conns"` // Concurrent request limit (0 uses the default)
+	Zone     string             `json:"zone" yaml:"zone"`           // Availability zone
+	Tags     map[string]string  `json:"tags" yaml:"tags"`           // Arbitrary key/value tags
+	TLS      *backend.TLSConfig `json:"tls" yaml:"tls"`             // TLS settings for https backends
+}
+
+// equal reports whether two targets describe the same backend configuration.
+func (t Target) equal(other Target) bool {
+	return t.Addr == other.Addr &&
+		t.Weight == other.Weight &&
+		t.Priority == other.Priority &&
+		t.MaxConns == other.MaxConns &&
+		t.Zone == other.Zone &&
+		maps.Equal(t.Tags, other.Tags) &&
+		(t.TLS == other.TLS || t.TLS != nil && other.TLS != nil && *t.TLS == *other.TLS)
+}
+
+// BackendDefaults holds settings for discovered backends that targets do
+// not specify themselves.
+type BackendDefaults struct {
+	Breaker   *backend.BreakerSettings // Circuit breaker settings (nil disables)
+	TLS       *backend.TLSConfig       // TLS settings for https targets without their own
+	MaxConns  int                      // Concurrent request limit for targets without their own (0 means unlimited)
+	Transport backend.TransportOptions // Connection tuning for all targets
+}
+
+// NewBackend creates a backend for the target, filling in the defaults.
+func (d BackendDefaults) NewBackend(target Target) *backend.Backend {
+	b := backend.New(target.Addr, d.Breaker)
+	b.TLS = cmp.Or(target.TLS, d.TLS)
+	b.TransportOptions = d.Transport
+	b.Weight = target.Weight
+	b.Priority = target.Priority
+	b.MaxConns = cmp.Or(target.MaxConns, d.MaxConns)
+	b.Zone = target.Zone
+	b.Tags = target.Tags
+	return b
+}
+
+// Registry is the set of live backends a Reconciler keeps in sync.
+// It is implemented by server.Server, which wires backends into the
+// balancer, the health checker and the proxy cache.
+type Registry interface {
+	AddBackend(b *backend.Backend) error
+	RemoveBackend(addr string) (*backend.Backend, error)
+	GetBackend(addr string) (*backend.Backend, error)
+}
+
+// Reconciler applies discovery events to a Registry.
+// It only manages backends it added itself, so backends registered through
+// other means (such as the admin API) are left untouched.
+type Reconciler struct {
+	registry Registry
+	defaults BackendDefaults
+	synced   chan struct{} // Closed after the first event is applied
+	once     sync.Once
+
+	mu      sync.Mutex
+	managed map[string]Target // Targets currently applied, by address
+}
+
+// NewReconciler creates a reconciler that registers discovered backends
+// in registry, applying defaults to settings targets do not specify.
+func NewReconciler(registry Registry, defaults BackendDefaults) *Reconciler {
+	return &Reconciler{
+		registry: registry,
+		defaults: defaults,
+		synced:   make(chan struct{}),
+		managed:  make(map[string]Target),
+	}
+}
+
+// Run applies events from the provider until the context is cancelled
+// or the provider stops.
+func (r *Reconciler) Run(ctx context.Context, d Discovery) error {
+	events := make(chan Event)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx, events)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-done:
+			return err
+		case event := <-events:
+			r.Handle(event)
+		}
+	}
+}
+
+// Synced returns a channel that is closed once the first event has been applied.
+func (r *Reconciler) Synced() <-chan struct{} {
+	return r.synced
+}
+
+// Handle applies a single discovery event, either a full snapshot or a delta.
+func (r *Reconciler) Handle(event Event) {
+	if event.Full {
+		r.Apply(event.Targets)
+		return
+	}
+
+	r.mu.Lock()
+	desired := make(targetSet, len(r.managed))
+	for addr, t := range r.managed {
+		desired[addr] = t
+	}
+	r.mu.Unlock()
+
+	desired.apply(event)
+	targets := make([]Target, 0, len(desired))
+	for _, t := range desired {
+		targets = append(targets, t)
+	}
+	r.Apply(targets)
+}
+
+// Apply reconciles the registry with the given snapshot: new targets are
+// added, missing ones removed and changed ones replaced. Backends whose
+// target did not change are kept as-is, preserving their health state.
+func (r *Reconciler) Apply(targets []Target) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.once.Do(func() { close(r.synced) })
+
+	desired := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		desired[t.Addr] = t
+	}
+
+	for addr := range r.managed {
+		if _, ok := desired[addr]; !ok {
+			r.remove(addr)
+		}
+	}
+
+	for addr, target := range desired {
+		current, ok := r.managed[addr]
+		switch {
+		case !ok:
+			r.add(target, backend.StateUnhealthy)
+		case !current.equal(target):
+			// Carry the health state over so the replacement does not
+			// drop out of rotation until the next health check.
+			state := backend.StateUnhealthy
+			if old, err := r.registry.GetBackend(addr); err == nil {
+				state = old.State()
+			}
+			r.remove(addr)
+			r.add(target, state)
+		}
+	}
+}
+
+// add registers a backend for the target. Must be called with mu held.
+func (r *Reconciler) add(target Target, state backend.State) {
+	b := r.defaults.NewBackend(target)
+	b.SetState(state)
+
+	// Targets the pool cannot connect to, such as https targets of an h2c
+	// pool, are rejected up front rather than failing every request
+	if _, err := b.URL(); err != nil {
+		log.Warn().Err(err).Str("backend", target.Addr).Msg("Ignoring discovered backend")
+		return
+	}
+
+	if err := r.registry.AddBackend(b); err != nil {
+		log.Warn().Err(err).Str("backend", target.Addr).Msg("Failed to add discovered backend")
+		return
+	}
+	r.managed[target.Addr] = target
+}
+
+// remove unregisters a managed backend. Must be called with mu held.
+func (r *Reconciler) remove(addr string) {
+	if _, err := r.registry.RemoveBackend(addr); err != nil {
+		log.Warn().Err(err).Str("backend", addr).Msg("Failed to remove discovered backend")
+	}
+	delete(r.managed, addr)
+}
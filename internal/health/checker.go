@@ -2,18 +2,87 @@
 package health
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"load-balancer/internal/backend"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// maxProbeBodySize limits how much of a health response body is inspected.
+const maxProbeBodySize = 64 << 10
+
+// Probe describes how a backend's health endpoint is queried and how the
+// response is mapped to a backend.State.
+type Probe struct {
+	Path                string        // Health endpoint path
+	DegradedLatency     time.Duration // Responses slower than this mark the backend degraded (0 disables)
+	DegradedStatusCodes []int         // Status codes that mark the backend degraded
+	DegradedBody        string        // Body substring that marks the backend degraded (empty disables)
+}
+
+// DefaultProbe returns a probe that queries /health and only distinguishes
+// between healthy and unhealthy backends.
+func DefaultProbe() Probe {
+	return Probe{Path: "/health"}
+}
+
+// Evaluate determines the backend state from a health check response.
+func (p Probe) Evaluate(statusCode int, latency time.Duration, body []byte) backend.State {
+	if slices.Contains(p.DegradedStatusCodes, statusCode) {
+		return backend.StateDegraded
+	}
+	if statusCode != http.StatusOK {
+		return backend.StateUnhealthy
+	}
+	if p.DegradedLatency > 0 && latency > p.DegradedLatency {
+		return backend.StateDegraded
+	}
+	if p.DegradedBody != "" && bytes.Contains(body, []byte(p.DegradedBody)) {
+		return backend.StateDegraded
+	}
+	return backend.StateHealthy
+}
+
+// Checker periodically probes a changing set of backends.
+type Checker struct {
+	probe    Probe
+	interval time.Duration
+
+	mu       sync.RWMutex
+	backends map[string]*backend.Backend // Checked backends by address
+}
+
+// NewChecker creates a health checker for the given backends.
+func NewChecker(backends []*backend.Backend, interval time.Duration, probe Probe) *Checker {
+	c := &Checker{
+		probe:    probe,
+		interval: interval,
+		backends: make(map[string]*backend.Backend, len(backends)),
+	}
+	for _, b := range backends {
+		c.backends[b.Addr] = b
+	}
+	return c
+}
+
 // StartHealthCheck starts periodic health checks for all backends.
 // It runs in a goroutine and stops when the context is cancelled.
-func StartHealthCheck(ctx context.Context, backends []*backend.Backend, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+// The returned Checker can be used to add and remove backends at runtime.
+func StartHealthCheck(ctx context.Context, backends []*backend.Backend, interval time.Duration, probe Probe) *Checker {
+	c := NewChecker(backends, interval, probe)
+	c.Start(ctx)
+	return c
+}
+
+// Start runs periodic health checks in a goroutine until the context is cancelled.
+func (c *Checker) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
 
 	go func() {
 		defer ticker.Stop()
@@ -23,22 +92,57 @@ func StartHealthCheck(ctx context.Context, backends []*backend.Backend, interval
 				log.Info().Msg("Health checks stopped")
 				return
 			case <-ticker.C:
-				for _, b := range backends {
-					go checkBackend(b)
+				for _, b := range c.Backends() {
+					go c.Check(b)
 				}
 			}
 		}
 	}()
 }
 
-var healthCheckClient = &http.Client{
-	Timeout: 5 * time.Second,
+// Add registers a backend for periodic checks and probes it immediately
+// in the background so it can start receiving traffic without waiting
+// for the next tick.
+func (c *Checker) Add(b *backend.Backend) {
+	c.mu.Lock()
+	c.backends[b.Addr] = b
+	c.mu.Unlock()
+	go c.Check(b)
+}
+
+// Remove stops checking the backend with the given address.
+func (c *Checker) Remove(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.backends, addr)
+}
+
+// Backends returns a snapshot of the backends being checked.
+func (c *Checker) Backends() []*backend.Backend {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	backends := make([]*backend.Backend, 0, len(c.backends))
+	for _, b := range c.backends {
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+// Check probes a single backend and updates its state.
+func (c *Checker) Check(b *backend.Backend) {
+	checkBackend(b, c.probe)
 }
 
-func checkBackend(b *backend.Backend) {
-	resp, err := healthCheckClient.Get("http://" + b.Addr + "/health")
+// healthCheckTimeout limits a single health check request.
+const healthCheckTimeout = 5 * time.Second
+
+// checkBackend probes the backend through its own transport, so health
+// checks use the same scheme, base path, socket and TLS settings as proxying.
+func checkBackend(b *backend.Backend, probe Probe) {
+	start := time.Now()
+	resp, err := probeBackend(b, probe)
 	if err != nil {
-		b.SetAlive(false)
+		b.SetState(backend.StateUnhealthy)
 		log.Info().
 			Str("backend", b.Addr).
 			Bool("alive", false).
@@ -48,16 +152,41 @@ func checkBackend(b *backend.Backend) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		b.SetAlive(true)
-	} else {
-		b.SetAlive(false)
+	var body []byte
+	if probe.DegradedBody != "" {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+		if err != nil {
+			log.Warn().Err(err).Str("backend", b.Addr).Msg("Failed to read health check body")
+		}
 	}
+	latency := time.Since(start)
+
+	b.SetState(probe.Evaluate(resp.StatusCode, latency, body))
 
 	// Log the health status
 	log.Info().
 		Str("backend", b.Addr).
 		Bool("alive", b.IsAlive()).
+		Stringer("state", b.State()).
 		Int("status_code", resp.StatusCode).
+		Dur("latency", latency).
 		Msg("Backend health status updated")
 }
+
+// probeBackend sends the health check request for a backend.
+func probeBackend(b *backend.Backend, probe Probe) (*http.Response, error) {
+	baseURL, err := b.URL()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := b.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   healthCheckTimeout,
+	}
+	return client.Get(baseURL.JoinPath(probe.Path).String())
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -20,7 +21,7 @@ func TestHealthCheck(t *testing.T) {
 	backend := &backend.Backend{Addr: server.Listener.Addr().String()}
 
 	// Run health check
-	checkBackend(backend)
+	checkBackend(backend, DefaultProbe())
 
 	if !backend.IsAlive() {
 		t.Error("Backend should be marked as alive")
@@ -39,9 +40,52 @@ func TestHealthCheck_UnhealthyBackend(t *testing.T) {
 	backend := &backend.Backend{Addr: server.Listener.Addr().String()}
 
 	// Run health check
-	checkBackend(backend)
+	checkBackend(backend, DefaultProbe())
 
 	if backend.IsAlive() {
 		t.Error("Backend should be marked as unhealthy")
 	}
 }
+
+func TestHealthCheck_DegradedBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.Write([]byte(`{"status":"degraded"}`))
+		}
+	}))
+	defer server.Close()
+
+	b := &backend.Backend{Addr: server.Listener.Addr().String()}
+	probe := DefaultProbe()
+	probe.DegradedBody = `"degraded"`
+
+	checkBackend(b, probe)
+
+	if !b.IsDegraded() {
+		t.Errorf("Backend should be marked as degraded, got %s", b.State())
+	}
+}
+
+func TestProbe_Evaluate(t *testing.T) {
+	probe := Probe{
+		DegradedLatency:     100 * time.Millisecond,
+		DegradedStatusCodes: []int{http.StatusTooManyRequests},
+	}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		latency    time.Duration
+		expected   backend.State
+	}{
+		{"ok", http.StatusOK, 10 * time.Millisecond, backend.StateHealthy},
+		{"slow", http.StatusOK, time.Second, backend.StateDegraded},
+		{"degraded status", http.StatusTooManyRequests, 10 * time.Millisecond, backend.StateDegraded},
+		{"server error", http.StatusInternalServerError, 10 * time.Millisecond, backend.StateUnhealthy},
+	}
+	for _, tt := range tests {
+		if actual := probe.Evaluate(tt.statusCode, tt.latency, nil); actual != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, actual)
+		}
+	}
+}
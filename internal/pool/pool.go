@@ -0,0 +1,132 @@
+// Package pool groups backends into named upstream pools. Each pool has its
+// own balancing strategy, health checks and backend settings, so a single
+// load balancer can front several services.
+package pool
+
+import (
+	"context"
+	"errors"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/health"
+	"sync"
+	"time"
+)
+
+// Default is the name of the pool built from the environment configuration.
+const Default = "default"
+
+var (
+	// ErrPoolExists is returned when registering a pool whose name is taken.
+	ErrPoolExists = errors.New("pool already exists")
+	// ErrPoolNotFound is returned when a requested pool is not registered.
+	ErrPoolNotFound = errors.New("pool not found")
+)
+
+// Config describes a pool.
+type Config struct {
+	Name           string
+	Strategy       balancer.Strategy         // Balancing strategy for the pool's backends
+	Probe          health.Probe              // Health check probe
+	HealthInterval time.Duration             // Time between health checks
+	Defaults       discovery.BackendDefaults // Settings applied to backends created for the pool
+	RequestTimeout time.Duration             // Total time allowed for a request (0 disables)
+}
+
+// Pool is a named group of backends with its own balancer and health checker.
+type Pool struct {
+	Name           string
+	Balancer       *balancer.Balancer
+	Health         *health.Checker
+	Defaults       discovery.BackendDefaults
+	RequestTimeout time.Duration
+}
+
+// New creates a pool and starts its health checks, which stop when the
+// context is cancelled.
+func New(ctx context.Context, cfg Config) *Pool {
+	return &Pool{
+		Name:           cfg.Name,
+		Balancer:       balancer.NewBalancer(cfg.Strategy, nil),
+		Health:         health.StartHealthCheck(ctx, nil, cfg.HealthInterval, cfg.Probe),
+		Defaults:       cfg.Defaults,
+		RequestTimeout: cfg.RequestTimeout,
+	}
+}
+
+// NewBackend creates a backend for the target with the pool's defaults.
+func (p *Pool) NewBackend(target discovery.Target) *backend.Backend {
+	return p.Defaults.NewBackend(target)
+}
+
+// AddBackend registers a backend with the pool's balancer and health checker.
+func (p *Pool) AddBackend(b *backend.Backend) error {
+	if err := p.Balancer.AddBackend(b); err != nil {
+		return err
+	}
+	p.Health.Add(b)
+	return nil
+}
+
+// RemoveBackend unregisters a backend from the pool's balancer and health
+// checker. Requests already in flight are unaffected.
+func (p *Pool) RemoveBackend(addr string) (*backend.Backend, error) {
+	b, err := p.Balancer.RemoveBackend(addr)
+	if err != nil {
+		return nil, err
+	}
+	p.Health.Remove(addr)
+	return b, nil
+}
+
+// GetBackend returns the backend with the given address.
+func (p *Pool) GetBackend(addr string) (*backend.Backend, error) {
+	return p.Balancer.GetBackend(addr)
+}
+
+// Registry looks pools up by name.
+type Registry struct {
+	mu    sync.RWMutex
+	pools map[string]*Pool
+	names []string // Registration order
+}
+
+// NewRegistry creates an empty pool registry.
+func NewRegistry() *Registry {
+	return &Registry{pools: make(map[string]*Pool)}
+}
+
+// Add registers a pool under its name.
+func (r *Registry) Add(p *Pool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.pools[p.Name]; exists {
+		return ErrPoolExists
+	}
+	r.pools[p.Name] = p
+	r.names = append(r.names, p.Name)
+	return nil
+}
+
+// Get returns the pool with the given name.
+func (r *Registry) Get(name string) (*Pool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, exists := r.pools[name]
+	if !exists {
+		return nil, ErrPoolNotFound
+	}
+	return p, nil
+}
+
+// Pools returns all pools in registration order.
+func (r *Registry) Pools() []*Pool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	pools := make([]*Pool, 0, len(r.names))
+	for _, name := range r.names {
+		pools = append(pools, r.pools[name])
+	}
+	return pools
+}
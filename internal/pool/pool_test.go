@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/health"
+	"testing"
+	"time"
+)
+
+func newTestPool(ctx context.Context, name string) *Pool {
+	return New(ctx, Config{
+		Name:           name,
+		Strategy:       balancer.NewRoundRobinStrategy(nil),
+		Probe:          health.DefaultProbe(),
+		HealthInterval: time.Hour,
+		Defaults:       discovery.BackendDefaults{MaxConns: 10},
+	})
+}
+
+func TestRegistry_AddGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewRegistry()
+	for _, name := range []string{Default, "api"} {
+		if err := r.Add(newTestPool(ctx, name)); err != nil {
+			t.Fatalf("Unexpected error adding pool %s: %v", name, err)
+		}
+	}
+	if err := r.Add(newTestPool(ctx, "api")); !errors.Is(err, ErrPoolExists) {
+		t.Errorf("Expected ErrPoolExists, got %v", err)
+	}
+
+	if p, err := r.Get("api"); err != nil || p.Name != "api" {
+		t.Errorf("Expected pool api, got %v, %v", p, err)
+	}
+	if _, err := r.Get("static"); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("Expected ErrPoolNotFound, got %v", err)
+	}
+
+	pools := r.Pools()
+	if len(pools) != 2 || pools[0].Name != Default || pools[1].Name != "api" {
+		t.Errorf("Expected pools in registration order, got %v", pools)
+	}
+}
+
+func TestPool_Backends(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPool(ctx, "api")
+
+	b := p.NewBackend(discovery.Target{Addr: "localhost:1", Weight: 2})
+	if b.MaxConns != 10 || b.Weight != 2 {
+		t.Errorf("Expected pool defaults and target settings, got max_conns=%d weight=%d", b.MaxConns, b.Weight)
+	}
+	if err := p.AddBackend(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(p.Health.Backends()) != 1 {
+		t.Error("Expected backend to be health checked")
+	}
+
+	if _, err := p.RemoveBackend("localhost:1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := p.GetBackend("localhost:1"); !errors.Is(err, balancer.ErrBackendNotFound) {
+		t.Errorf("Expected removed backend to be gone, got %v", err)
+	}
+	if len(p.Health.Backends()) != 0 {
+		t.Error("Expected removed backend to no longer be health checked")
+	}
+}
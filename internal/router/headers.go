@@ -0,0 +1,66 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// Template variables available in header rule values, written as {name}.
+const (
+	VarClientIP  = "client_ip"  // Address of the client
+	VarRequestID = "request_id" // Request ID, taken from X-Request-ID or generated
+	VarBackend   = "backend"    // Address of the backend serving the request
+	VarClientID  = "client_id"  // ID of the API client
+)
+
+// templateVar matches a {name} placeholder in a header value.
+var templateVar = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// HeaderRules modifies a set of headers. Rules are applied in the order
+// remove, set, add. Values may contain template variables such as
+// {client_ip}.
+type HeaderRules struct {
+	Add    map[string]string `yaml:"add"`    // Headers appended to existing values
+	Set    map[string]string `yaml:"set"`    // Headers replacing existing values
+	Remove []string          `yaml:"remove"` // Headers deleted
+}
+
+func (h *HeaderRules) validate() error {
+	for _, values := range []map[string]string{h.Add, h.Set} {
+		for name, value := range values {
+			for _, match := range templateVar.FindAllStringSubmatch(value, -1) {
+				switch match[1] {
+				case VarClientIP, VarRequestID, VarBackend, VarClientID:
+				default:
+					return fmt.Errorf("header %s uses unknown variable {%s}", name, match[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Apply modifies the headers, expanding template variables from vars.
+// It does nothing on nil rules.
+func (h *HeaderRules) Apply(header http.Header, vars map[string]string) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, value := range h.Set {
+		header.Set(name, expand(value, vars))
+	}
+	for name, value := range h.Add {
+		header.Add(name, expand(value, vars))
+	}
+}
+
+// expand replaces template variables in value.
+func expand(value string, vars map[string]string) string {
+	return templateVar.ReplaceAllStringFunc(value, func(placeholder string) string {
+		return vars[placeholder[1:len(placeholder)-1]]
+	})
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeaderRules_Apply(t *testing.T) {
+	rules := &HeaderRules{
+		Remove: []string{"Cookie", "X-Debug"},
+		Set:    map[string]string{"X-Request-ID": "{request_id}", "X-Client": "{client_id}@{client_ip}"},
+		Add:    map[string]string{"Via": "lb {backend}"},
+	}
+	header := http.Header{}
+	header.Set("Cookie", "session=1")
+	header.Set("X-Request-ID", "old")
+	header.Set("Via", "1.1 proxy")
+
+	rules.Apply(header, map[string]string{
+		VarClientIP:  "10.0.0.1",
+		VarRequestID: "abc",
+		VarBackend:   "10.0.1.1:8080",
+		VarClientID:  "user1",
+	})
+
+	if header.Get("Cookie") != "" {
+		t.Error("Expected Cookie to be removed")
+	}
+	if got := header.Get("X-Request-ID"); got != "abc" {
+		t.Errorf("Expected X-Request-ID abc, got %s", got)
+	}
+	if got := header.Get("X-Client"); got != "user1@10.0.0.1" {
+		t.Errorf("Expected X-Client user1@10.0.0.1, got %s", got)
+	}
+	if got := header.Values("Via"); len(got) != 2 || got[1] != "lb 10.0.1.1:8080" {
+		t.Errorf("Expected Via to be appended, got %v", got)
+	}
+
+	var none *HeaderRules
+	none.Apply(header, nil) // Must not panic
+}
+
+func TestHeaderRules_UnknownVariable(t *testing.T) {
+	route := Route{Pool: "api", ResponseHeaders: &HeaderRules{Set: map[string]string{"X-Host": "{hostname}"}}}
+	if _, err := New([]Route{route}); err == nil {
+		t.Error("Expected error for unknown template variable")
+	}
+}
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"errors"
+	"time"
+)
+
+// Hedge sends a duplicate of a slow request to a second backend and uses
+// whichever response arrives first. Only idempotent requests are hedged.
+// Until enough latencies are observed for a percentile, Delay is used, or
+// requests are not hedged if it is unset.
+type Hedge struct {
+	Delay      time.Duration `yaml:"delay"`      // Time without a response before the duplicate is sent
+	Percentile float64       `yaml:"percentile"` // Use this percentile of the route's recent latency as the delay instead
+}
+
+// validate checks that the hedge has a usable delay or percentile.
+func (h *Hedge) validate() error {
+	if h.Delay < 0 {
+		return errors.New("hedge delay cannot be negative")
+	}
+	if h.Percentile < 0 || h.Percentile >= 100 {
+		return errors.New("hedge percentile must be between 0 and 100")
+	}
+	if h.Delay == 0 && h.Percentile == 0 {
+		return errors.New("hedge requires a delay or a percentile")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Rewrite replaces the request path using a regular expression.
+type Rewrite struct {
+	Regex       string `yaml:"regex"`       // Expression matched against the escaped path
+	Replacement string `yaml:"replacement"` // Replacement with $1 or ${name} references; may end in ?query
+
+	re *regexp.Regexp
+}
+
+func (rw *Rewrite) compile() error {
+	re, err := regexp.Compile(rw.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid rewrite regex: %w", err)
+	}
+	if !strings.HasPrefix(rw.Replacement, "/") {
+		return errors.New("rewrite replacement must start with /")
+	}
+	rw.re = re
+	return nil
+}
+
+// RewriteURL applies the route's prefix stripping and then its regex
+// rewrite to the outgoing URL. Both operate on the escaped path, so encoded
+// characters such as %2F reach the backend unchanged. A query produced by
+// the rewrite is prepended to the request's own query.
+func (r *Route) RewriteURL(u *url.URL) {
+	if r.StripPrefix == "" && r.Rewrite == nil {
+		return
+	}
+
+	path := u.EscapedPath()
+	if r.StripPrefix != "" {
+		path = stripPrefix(path, r.StripPrefix)
+	}
+
+	var query string
+	if r.Rewrite != nil && r.Rewrite.re.MatchString(path) {
+		path, query, _ = strings.Cut(r.Rewrite.re.ReplaceAllString(path, r.Rewrite.Replacement), "?")
+	}
+
+	setEscapedPath(u, path)
+	if query != "" {
+		if u.RawQuery != "" {
+			query += "&" + u.RawQuery
+		}
+		u.RawQuery = query
+	}
+}
+
+// stripPrefix removes the decoded prefix from an escaped path on a segment
+// boundary. The escaped form of the prefix is located by decoding candidate
+// boundaries, since the client may have encoded characters within it.
+func stripPrefix(escaped, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	for i := 0; i <= len(escaped); i++ {
+		if i < len(escaped) && escaped[i] != '/' {
+			continue
+		}
+		decoded, err := url.PathUnescape(escaped[:i])
+		if err != nil || len(decoded) > len(prefix) {
+			break
+		}
+		if decoded == prefix {
+			if rest := escaped[i:]; rest != "" {
+				return rest
+			}
+			return "/"
+		}
+	}
+	return escaped
+}
+
+// setEscapedPath sets both forms of the URL path from an escaped path.
+func setEscapedPath(u *url.URL, escaped string) {
+	decoded, err := url.PathUnescape(escaped)
+	if err != nil {
+		// The rewrite produced an invalid escape; send it as a literal path
+		u.Path, u.RawPath = escaped, ""
+		return
+	}
+	u.Path, u.RawPath = decoded, escaped
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRoute_RewriteURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		route     Route
+		in        string
+		wantPath  string // Escaped path sent upstream
+		wantQuery string
+	}{
+		{
+			name:     "strip prefix",
+			route:    Route{StripPrefix: "/api/users"},
+			in:       "/api/users/42/orders",
+			wantPath: "/42/orders",
+		},
+		{
+			name:     "strip whole path",
+			route:    Route{StripPrefix: "/api/users/"},
+			in:       "/api/users",
+			wantPath: "/",
+		},
+		{
+			name:     "strip only on segment boundary",
+			route:    Route{StripPrefix: "/api/users"},
+			in:       "/api/usersettings",
+			wantPath: "/api/usersettings",
+		},
+		{
+			name:     "strip keeps encoded slash",
+			route:    Route{StripPrefix: "/api/users"},
+			in:       "/api/users/a%2Fb/c%20d",
+			wantPath: "/a%2Fb/c%20d",
+		},
+		{
+			name:     "strip encoded prefix",
+			route:    Route{StripPrefix: "/api/users"},
+			in:       "/api/%75sers/42",
+			wantPath: "/42",
+		},
+		{
+			name:     "strip prefix containing encoded slash",
+			route:    Route{StripPrefix: "/api/users"},
+			in:       "/api%2Fusers/42",
+			wantPath: "/42",
+		},
+		{
+			name:     "regex capture groups",
+			route:    Route{Rewrite: &Rewrite{Regex: `^/api/users/([^/]+)/(.*)$`, Replacement: "/v2/$2/by-user/$1"}},
+			in:       "/api/users/42/orders",
+			wantPath: "/v2/orders/by-user/42",
+		},
+		{
+			name:     "regex keeps encoded characters",
+			route:    Route{Rewrite: &Rewrite{Regex: `^/files/(.*)$`, Replacement: "/storage/${1}"}},
+			in:       "/files/dir%2Fname/%E2%9C%93.txt",
+			wantPath: "/storage/dir%2Fname/%E2%9C%93.txt",
+		},
+		{
+			name:      "regex adds query",
+			route:     Route{Rewrite: &Rewrite{Regex: `^/users/([0-9]+)$`, Replacement: "/user?id=$1"}},
+			in:        "/users/42?fields=name",
+			wantPath:  "/user",
+			wantQuery: "id=42&fields=name",
+		},
+		{
+			name:     "regex without match",
+			route:    Route{Rewrite: &Rewrite{Regex: `^/users/([0-9]+)$`, Replacement: "/user/$1"}},
+			in:       "/users/me",
+			wantPath: "/users/me",
+		},
+		{
+			name:     "strip then rewrite",
+			route:    Route{StripPrefix: "/api", Rewrite: &Rewrite{Regex: `^/v1/(.*)$`, Replacement: "/$1"}},
+			in:       "/api/v1/a%2Fb",
+			wantPath: "/a%2Fb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.Pool = "test"
+			r, err := New([]Route{tt.route})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			u, err := url.Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Invalid test URL: %v", err)
+			}
+
+			r.routes[0].RewriteURL(u)
+			if got := u.EscapedPath(); got != tt.wantPath {
+				t.Errorf("Expected path %s, got %s", tt.wantPath, got)
+			}
+			if decoded, _ := url.PathUnescape(tt.wantPath); u.Path != decoded {
+				t.Errorf("Expected decoded path %s, got %s", decoded, u.Path)
+			}
+			if u.RawQuery != tt.wantQuery {
+				t.Errorf("Expected query %q, got %q", tt.wantQuery, u.RawQuery)
+			}
+		})
+	}
+}
+
+func TestRewrite_Invalid(t *testing.T) {
+	invalid := []Route{
+		{StripPrefix: "api", Pool: "api"},
+		{Rewrite: &Rewrite{Regex: "([", Replacement: "/"}, Pool: "api"},
+		{Rewrite: &Rewrite{Regex: "^/a", Replacement: "b"}, Pool: "api"},
+	}
+	for _, route := range invalid {
+		if _, err := New([]Route{route}); err == nil {
+			t.Errorf("Expected error for route %+v", route)
+		}
+	}
+}
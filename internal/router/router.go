@@ -0,0 +1,265 @@
+// Package router selects the backend pool for a request from a table of
+// routes matching on host, path, method and headers.
+package router
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Route matches requests and names the pool that serves them. All
+// configured conditions must hold for a request to match; at most one of
+// Path, PathPrefix and PathRegex may be set.
+type Route struct {
+	Name       string            `yaml:"name"`        // Name used in logs (optional)
+	Host       string            `yaml:"host"`        // Exact host, or a wildcard such as *.example.com
+	Path       string            `yaml:"path"`        // Exact path
+	PathPrefix string            `yaml:"path_prefix"` // Path prefix, matched on segment boundaries
+	PathRegex  string            `yaml:"path_regex"`  // Regular expression matched against the path
+	Methods    []string          `yaml:"methods"`     // Allowed methods (any if empty)
+	Headers    map[string]string `yaml:"headers"`     // Required headers; an empty value only requires presence
+	Pool       string            `yaml:"pool"`        // Pool serving matching requests
+
+	StripPrefix string   `yaml:"strip_prefix"` // Prefix removed from the path before proxying
+	Rewrite     *Rewrite `yaml:"rewrite"`      // Regex rewrite applied after prefix stripping
+
+	RequestHeaders  *HeaderRules `yaml:"request_headers"`  // Changes to headers sent upstream
+	ResponseHeaders *HeaderRules `yaml:"response_headers"` // Changes to headers sent to the client
+
+	Hedge    *Hedge    `yaml:"hedge"`    // Duplicates slow idempotent requests to a second backend
+	Timeouts *Timeouts `yaml:"timeouts"` // Overrides of the pool's and server's timeouts
+
+	Streaming bool `yaml:"streaming"` // Treat all responses as streams, e.g. for chunked feeds
+
+	host      string // Normalized host, without the wildcard
+	wildcard  bool
+	pathRegex *regexp.Regexp
+	index     int // Position in the configuration, the final tie breaker
+}
+
+// Host and path match kinds, ordered from least to most specific.
+const (
+	matchAny = iota
+	matchPrefix
+	matchRegex
+	matchExact
+)
+
+// Router finds the most specific route matching a request.
+type Router struct {
+	routes []*Route // Ordered from most to least specific
+}
+
+// New compiles the routes into a router.
+func New(routes []Route) (*Router, error) {
+	compiled := make([]*Route, 0, len(routes))
+	for i, route := range routes {
+		if err := route.compile(i); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.Label(), err)
+		}
+		compiled = append(compiled, &route)
+	}
+	slices.SortStableFunc(compiled, compareSpecificity)
+	return &Router{routes: compiled}, nil
+}
+
+// Match returns the most specific route matching the request, or nil.
+func (r *Router) Match(req *http.Request) *Route {
+	host := normalizeHost(req.Host)
+	for _, route := range r.routes {
+		if route.matches(req, host) {
+			return route
+		}
+	}
+	return nil
+}
+
+// Label returns the route's name, or its position in the configuration.
+func (r *Route) Label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", r.index+1)
+}
+
+func (r *Route) compile(index int) error {
+	r.index = index
+	if r.Pool == "" {
+		return errors.New("pool is required")
+	}
+
+	paths := 0
+	for _, p := range []string{r.Path, r.PathPrefix, r.PathRegex} {
+		if p != "" {
+			paths++
+		}
+	}
+	if paths > 1 {
+		return errors.New("only one of path, path_prefix and path_regex may be set")
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") || r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return errors.New("path must start with /")
+	}
+	if r.StripPrefix != "" && !strings.HasPrefix(r.StripPrefix, "/") {
+		return errors.New("strip_prefix must start with /")
+	}
+	if r.Rewrite != nil {
+		rewrite := *r.Rewrite
+		if err := rewrite.compile(); err != nil {
+			return err
+		}
+		r.Rewrite = &rewrite
+	}
+	for _, rules := range []*HeaderRules{r.RequestHeaders, r.ResponseHeaders} {
+		if rules == nil {
+			continue
+		}
+		if err := rules.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Hedge != nil {
+		if err := r.Hedge.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Timeouts != nil {
+		if err := r.Timeouts.validate(); err != nil {
+			return err
+		}
+	}
+	if r.PathRegex != "" {
+		re, err := regexp.Compile(r.PathRegex)
+		if err != nil {
+			return fmt.Errorf("invalid path_regex: %w", err)
+		}
+		r.pathRegex = re
+	}
+
+	if r.Host != "" {
+		host, wildcard := strings.CutPrefix(strings.ToLower(r.Host), "*.")
+		if host == "" || strings.Contains(host, "*") {
+			return fmt.Errorf("invalid host %q", r.Host)
+		}
+		r.host = strings.TrimSuffix(host, ".")
+		r.wildcard = wildcard
+	}
+
+	methods := make([]string, 0, len(r.Methods))
+	for _, method := range r.Methods {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	r.Methods = methods
+	return nil
+}
+
+func (r *Route) matches(req *http.Request, host string) bool {
+	switch {
+	case r.host == "":
+	case r.wildcard:
+		if !strings.HasSuffix(host, "."+r.host) {
+			return false
+		}
+	case host != r.host:
+		return false
+	}
+
+	path := req.URL.Path
+	switch {
+	case r.Path != "":
+		if path != r.Path {
+			return false
+		}
+	case r.PathPrefix != "":
+		if !hasPathPrefix(path, r.PathPrefix) {
+			return false
+		}
+	case r.pathRegex != nil:
+		if !r.pathRegex.MatchString(path) {
+			return false
+		}
+	}
+
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, req.Method) {
+		return false
+	}
+
+	for name, want := range r.Headers {
+		value := req.Header.Get(name)
+		if value == "" || want != "" && value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPathPrefix reports whether prefix matches path on a segment boundary:
+// /api matches /api and /api/users but not /apis.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// normalizeHost strips the port and trailing dot from a Host header and
+// lower-cases it.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostRank orders host conditions: exact hosts first, then wildcards with
+// longer suffixes, then routes matching any host.
+func (r *Route) hostRank() (int, int) {
+	switch {
+	case r.host == "":
+		return matchAny, 0
+	case r.wildcard:
+		return matchPrefix, len(r.host)
+	default:
+		return matchExact, len(r.host)
+	}
+}
+
+// pathRank orders path conditions: exact paths first, then regular
+// expressions, then longer prefixes, then routes matching any path.
+func (r *Route) pathRank() (int, int) {
+	switch {
+	case r.Path != "":
+		return matchExact, len(r.Path)
+	case r.pathRegex != nil:
+		return matchRegex, 0
+	case r.PathPrefix != "":
+		return matchPrefix, len(r.PathPrefix)
+	default:
+		return matchAny, 0
+	}
+}
+
+// compareSpecificity sorts more specific routes first: by host, then path,
+// then whether methods are restricted, then the number of header
+// conditions, and finally by configuration order.
+func compareSpecificity(a, b *Route) int {
+	aHost, aHostLen := a.hostRank()
+	bHost, bHostLen := b.hostRank()
+	aPath, aPathLen := a.pathRank()
+	bPath, bPathLen := b.pathRank()
+	return cmp.Or(
+		cmp.Compare(bHost, aHost),
+		cmp.Compare(bHostLen, aHostLen),
+		cmp.Compare(bPath, aPath),
+		cmp.Compare(bPathLen, aPathLen),
+		cmp.Compare(min(len(b.Methods), 1), min(len(a.Methods), 1)),
+		cmp.Compare(len(b.Headers), len(a.Headers)),
+		cmp.Compare(a.index, b.index),
+	)
+}
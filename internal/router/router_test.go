@@ -0,0 +1,81 @@
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouter_Match(t *testing.T) {
+	r, err := New([]Route{
+		{Name: "catch-all", Pool: "default"},
+		{Name: "api", PathPrefix: "/api", Pool: "api"},
+		{Name: "api-users", PathPrefix: "/api/users", Pool: "users"},
+		{Name: "api-versioned", PathRegex: `^/api/v[0-9]+/`, Pool: "versioned"},
+		{Name: "login", Path: "/api/login", Methods: []string{"post"}, Pool: "auth"},
+		{Name: "wildcard", Host: "*.example.com", Pool: "tenants"},
+		{Name: "static", Host: "static.example.com", Pool: "static"},
+		{Name: "canary", PathPrefix: "/api", Headers: map[string]string{"X-Canary": "1"}, Pool: "canary"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		method, url string
+		headers     map[string]string
+		want        string
+	}{
+		{"GET", "http://lb/", nil, "catch-all"},
+		{"GET", "http://lb/api", nil, "api"},
+		{"GET", "http://lb/api/orders", nil, "api"},
+		{"GET", "http://lb/apis", nil, "catch-all"},
+		{"GET", "http://lb/api/users/1", nil, "api-users"},
+		{"GET", "http://lb/api/v2/users", nil, "api-versioned"},
+		{"POST", "http://lb/api/login", nil, "login"},
+		{"GET", "http://lb/api/login", nil, "api"},
+		{"GET", "http://lb/api/orders", map[string]string{"X-Canary": "1"}, "canary"},
+		{"GET", "http://lb/api/orders", map[string]string{"X-Canary": "0"}, "api"},
+		{"GET", "http://tenant.example.com:8080/api", nil, "wildcard"},
+		{"GET", "http://STATIC.example.com/api", nil, "static"},
+		{"GET", "http://example.com/", nil, "catch-all"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		route := r.Match(req)
+		if route == nil || route.Name != tt.want {
+			t.Errorf("%s %s %v: expected route %s, got %v", tt.method, tt.url, tt.headers, tt.want, route)
+		}
+	}
+}
+
+func TestRouter_NoMatch(t *testing.T) {
+	r, err := New([]Route{{Host: "api.example.com", Pool: "api"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if route := r.Match(httptest.NewRequest("GET", "http://other.example.com/", nil)); route != nil {
+		t.Errorf("Expected no route, got %s", route.Label())
+	}
+}
+
+func TestRouter_InvalidRoutes(t *testing.T) {
+	invalid := []Route{
+		{PathPrefix: "/api"},
+		{Path: "/a", PathPrefix: "/a", Pool: "api"},
+		{PathPrefix: "api", Pool: "api"},
+		{PathRegex: "([", Pool: "api"},
+		{Host: "*.*.example.com", Pool: "api"},
+		{Hedge: &Hedge{}, Pool: "api"},
+		{Hedge: &Hedge{Percentile: 100}, Pool: "api"},
+		{Timeouts: &Timeouts{Write: -time.Second}, Pool: "api"},
+	}
+	for _, route := range invalid {
+		if _, err := New([]Route{route}); err == nil {
+			t.Errorf("Expected error for route %+v", route)
+		}
+	}
+}
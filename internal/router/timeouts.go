@@ -0,0 +1,22 @@
+package router
+
+import (
+	"errors"
+	"time"
+)
+
+// Timeouts overrides the timeouts of requests matching a route. Zero values
+// keep the pool's and server's settings.
+type Timeouts struct {
+	Request        time.Duration `yaml:"request"`         // Total time allowed for a request, including retries
+	ResponseHeader time.Duration `yaml:"response_header"` // Time to response headers per attempt, after which another backend is tried
+	Write          time.Duration `yaml:"write"`           // Time to write the response, e.g. longer for long polling
+	Stream         time.Duration `yaml:"stream"`          // Maximum duration of a streamed response, such as server-sent events
+}
+
+func (t *Timeouts) validate() error {
+	if t.Request < 0 || t.ResponseHeader < 0 || t.Write < 0 || t.Stream < 0 {
+		return errors.New("timeouts cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/pool"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// drainResponseGrace is added to the drain wait timeout when extending the
+// write deadline, leaving time to send the response.
+const drainResponseGrace = 5 * time.Second
+
+// backendStatus is the JSON representation of a backend in the admin API.
+type backendStatus struct {
+	Pool     string            `json:"pool"`
+	Addr     string            `json:"addr"`
+	State    string            `json:"state"`
+	Weight   int               `json:"weight"`
+	Priority int               `json:"priority"`
+	MaxConns int               `json:"max_conns"`
+	Zone     string            `json:"zone,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Circuit  string            `json:"circuit"`
+	Draining bool              `json:"draining"`
+	InFlight int64             `json:"in_flight"`
+	Tunnels  int64             `json:"tunnels"` // Upgraded connections, included in InFlight
+}
+
+// drainStatus is the response of the drain endpoint.
+type drainStatus struct {
+	backendStatus
+	Drained bool `json:"drained"` // True if no requests remain in flight
+}
+
+// poolStatus is the JSON representation of a pool in the admin API.
+type poolStatus struct {
+	Name      string `json:"name"`
+	Backends  int    `json:"backends"`
+	Available int    `json:"available"` // Backends that are alive and not draining
+}
+
+// addBackendRequest is the body of POST /admin/backends.
+type addBackendRequest struct {
+	Pool     string             `json:"pool"` // Target pool (default pool if empty)
+	Addr     string             `json:"addr"`
+	Weight   int                `json:"weight"`
+	Priority int                `json:"priority"`
+	MaxConns int                `json:"max_conns"`
+	Zone     string             `json:"zone"`
+	Tags     map[string]string  `json:"tags"`
+	TLS      *backend.TLSConfig `json:"tls"`
+}
+
+func newBackendStatus(p *pool.Pool, b *backend.Backend) backendStatus {
+	return backendStatus{
+		Pool:     p.Name,
+		Addr:     b.Addr,
+		State:    b.State().String(),
+		Weight:   b.Weight,
+		Priority: b.Priority,
+		MaxConns: b.MaxConns,
+		Zone:     b.Zone,
+		Tags:     b.Tags,
+		Circuit:  b.Breaker.State().String(),
+		Draining: b.IsDraining(),
+		InFlight: b.InFlight(),
+		Tunnels:  b.Tunnels(),
+	}
+}
+
+// registerAdminRoutes registers backend management routes on the given mux.
+// Backend routes act on the pool named by ?pool=, or on the default pool.
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/pools", s.handlePools)
+	mux.HandleFunc("/admin/backends", s.handleBackends)
+	mux.HandleFunc("/admin/backends/", s.handleBackendByAddr)
+}
+
+// requestPool returns the pool named by the ?pool= query parameter, or the
+// default pool. It writes a 404 response if the pool does not exist.
+func (s *Server) requestPool(w http.ResponseWriter, r *http.Request) (*pool.Pool, bool) {
+	p, err := s.Pools.Get(cmp.Or(r.URL.Query().Get("pool"), pool.Default))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return p, true
+}
+
+func (s *Server) handlePools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pools := s.Pools.Pools()
+	result := make([]poolStatus, 0, len(pools))
+	for _, p := range pools {
+		status := poolStatus{Name: p.Name}
+		for _, b := range p.Balancer.GetBackends() {
+			status.Backends++
+			if b.IsAlive() && !b.IsDraining() {
+				status.Available++
+			}
+		}
+		result = append(result, status)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleBackends serves the backend collection. Backends with URL addresses
+// cannot be named in the path, so GET and DELETE also accept ?addr=.
+func (s *Server) handleBackends(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	switch {
+	case r.Method == http.MethodPost:
+		s.addBackend(w, r)
+	case r.Method == http.MethodGet && addr != "":
+		s.getBackend(w, r, addr)
+	case r.Method == http.MethodGet:
+		s.listBackends(w, r)
+	case r.Method == http.MethodDelete && addr != "":
+		s.removeBackend(w, r, addr)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleBackendByAddr(w http.ResponseWriter, r *http.Request) {
+	addr := strings.TrimPrefix(r.URL.Path, "/admin/backends/")
+	if addr == "drain" {
+		s.handleDrain(w, r, r.URL.Query().Get("addr"))
+		return
+	}
+	if addr, ok := strings.CutSuffix(addr, "/drain"); ok {
+		s.handleDrain(w, r, addr)
+		return
+	}
+	if addr == "" {
+		http.Error(w, "Backend address required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getBackend(w, r, addr)
+	case http.MethodDelete:
+		s.removeBackend(w, r, addr)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) addBackend(w http.ResponseWriter, r *http.Request) {
+	var req addBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Addr == "" {
+		http.Error(w, "addr is required", http.StatusBadRequest)
+		return
+	}
+	if req.Weight < 0 {
+		http.Error(w, "weight cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if req.MaxConns < 0 {
+		http.Error(w, "max_conns cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if _, err := backend.ParseURL(req.Addr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := s.Pools.Get(cmp.Or(req.Pool, pool.Default))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	b := p.NewBackend(discovery.Target{
+		Addr:     req.Addr,
+		Weight:   req.Weight,
+		Priority: req.Priority,
+		MaxConns: req.MaxConns,
+		Zone:     req.Zone,
+		Tags:     req.Tags,
+		TLS:      req.TLS,
+	})
+	if _, err := b.URL(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.AddBackend(p, b); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newBackendStatus(p, b))
+}
+
+// listBackends lists the backends of the pool named by ?pool=, or of all
+// pools.
+func (s *Server) listBackends(w http.ResponseWriter, r *http.Request) {
+	pools := s.Pools.Pools()
+	if r.URL.Query().Get("pool") != "" {
+		p, ok := s.requestPool(w, r)
+		if !ok {
+			return
+		}
+		pools = []*pool.Pool{p}
+	}
+
+	result := []backendStatus{}
+	for _, p := range pools {
+		for _, b := range p.Balancer.GetBackends() {
+			result = append(result, newBackendStatus(p, b))
+		}
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) getBackend(w http.ResponseWriter, r *http.Request, addr string) {
+	p, ok := s.requestPool(w, r)
+	if !ok {
+		return
+	}
+	b, err := p.GetBackend(addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(newBackendStatus(p, b))
+}
+
+func (s *Server) removeBackend(w http.ResponseWriter, r *http.Request, addr string) {
+	p, ok := s.requestPool(w, r)
+	if !ok {
+		return
+	}
+	b, err := s.RemoveBackend(p, addr)
+	if err != nil {
+		if errors.Is(err, balancer.ErrBackendNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newBackendStatus(p, b))
+}
+
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request, addr string) {
+	p, ok := s.requestPool(w, r)
+	if !ok {
+		return
+	}
+	b, err := p.GetBackend(addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.drainBackend(w, r, p, b)
+	case http.MethodDelete:
+		b.SetDraining(false)
+		log.Info().Str("pool", p.Name).Str("backend", b.Addr).Msg("Backend draining cancelled")
+		json.NewEncoder(w).Encode(newBackendStatus(p, b))
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// drainBackend puts a backend into draining mode. With ?wait=true the call
+// blocks until no requests are in flight or the timeout (?timeout=, default
+// DRAIN_TIMEOUT) elapses.
+func (s *Server) drainBackend(w http.ResponseWriter, r *http.Request, p *pool.Pool, b *backend.Backend) {
+	query := r.URL.Query()
+	wait := query.Get("wait") == "true"
+	timeout := s.Config.DrainTimeout
+	if raw := query.Get("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+
+	b.SetDraining(true)
+	log.Info().
+		Str("pool", p.Name).
+		Str("backend", b.Addr).
+		Int64("in_flight", b.InFlight()).
+		Msg("Backend draining")
+
+	if wait {
+		// Waiting may outlast the server's write timeout
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(timeout + drainResponseGrace)); err != nil {
+			log.Debug().Err(err).Msg("Failed to extend write deadline for drain request")
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		if err := b.WaitDrained(ctx); err != nil {
+			log.Warn().
+				Str("backend", b.Addr).
+				Int64("in_flight", b.InFlight()).
+				Msg("Drain wait timed out")
+		}
+	}
+
+	json.NewEncoder(w).Encode(drainStatus{
+		backendStatus: newBackendStatus(p, b),
+		Drained:       b.InFlight() == 0,
+	})
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/config"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_AdminAPIOnlyOnAdminListener(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: echoBackend(t)})
+	if s.admin != nil {
+		t.Fatal("Expected the admin API to be disabled by default")
+	}
+
+	// On the public listener admin paths are proxied like any other
+	rec := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/backends?addr=x", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the public listener to require an API key, got %d", rec.Code)
+	}
+
+	cfg := &config.Config{ListenAddress: ":0", AdminListenAddress: "127.0.0.1:0", QueueSize: 1}
+	r, _ := router.New(nil)
+	admin := NewServer(cfg, pool.NewRegistry(), r)
+	if admin.admin == nil {
+		t.Fatal("Expected the admin listener to be configured")
+	}
+	rec = httptest.NewRecorder()
+	admin.admin.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/pools", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || string(body) != "[]\n" {
+		t.Errorf("Expected the admin API on the admin listener, got %d %q", rec.Code, body)
+	}
+}
+
+// adminRequest sends a request to the admin API of the server.
+func adminRequest(s *Server, method, target, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	s.registerAdminRoutes(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestAdmin_AddListRemoveBackend(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: echoBackend(t), "users": echoBackend(t)})
+	addr := echoBackend(t)
+
+	rec := adminRequest(s, http.MethodPost, "/admin/backends", `{"pool": "users", "addr": "`+addr+`", "weight": 3}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var added backendStatus
+	json.NewDecoder(rec.Body).Decode(&added)
+	if added.Pool != "users" || added.Addr != addr || added.Weight != 3 {
+		t.Errorf("Unexpected backend status: %+v", added)
+	}
+
+	var listed []backendStatus
+	json.NewDecoder(adminRequest(s, http.MethodGet, "/admin/backends", "").Body).Decode(&listed)
+	if len(listed) != 3 {
+		t.Errorf("Expected backends of all pools to be listed, got %+v", listed)
+	}
+	json.NewDecoder(adminRequest(s, http.MethodGet, "/admin/backends?pool=users", "").Body).Decode(&listed)
+	if len(listed) != 2 {
+		t.Errorf("Expected the backends of the users pool to be listed, got %+v", listed)
+	}
+
+	if rec := adminRequest(s, http.MethodGet, "/admin/backends?pool=users&addr="+url.QueryEscape(addr), ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the backend to be found by ?addr=, got %d", rec.Code)
+	}
+	if rec := adminRequest(s, http.MethodDelete, "/admin/backends?pool=users&addr="+url.QueryEscape(addr), ""); rec.Code != http.StatusAccepted {
+		t.Errorf("Expected the backend to be removed, got %d", rec.Code)
+	}
+	p, _ := s.Pools.Get("users")
+	if _, err := p.GetBackend(addr); err == nil {
+		t.Error("Expected the backend to be removed from the pool")
+	}
+}
+
+func TestAdmin_PathAddressing(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: echoBackend(t)})
+	rec := adminRequest(s, http.MethodPost, "/admin/backends", `{"addr": "127.0.0.1:1"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = adminRequest(s, http.MethodGet, "/admin/backends/127.0.0.1:1", "")
+	var status backendStatus
+	json.NewDecoder(rec.Body).Decode(&status)
+	if rec.Code != http.StatusOK || status.Addr != "127.0.0.1:1" {
+		t.Errorf("Expected the backend to be found by path, got %d %+v", rec.Code, status)
+	}
+	if rec := adminRequest(s, http.MethodDelete, "/admin/backends/127.0.0.1:1", ""); rec.Code != http.StatusAccepted {
+		t.Errorf("Expected the backend to be removed by path, got %d", rec.Code)
+	}
+	if rec := adminRequest(s, http.MethodGet, "/admin/backends/127.0.0.1:1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after removal, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Errors(t *testing.T) {
+	upstream := echoBackend(t)
+	s := newTestServer(t, nil, map[string]string{pool.Default: upstream})
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+	}{
+		{"invalid json", http.MethodPost, "/admin/backends", `{"addr":`, http.StatusBadRequest},
+		{"missing addr", http.MethodPost, "/admin/backends", `{"weight": 1}`, http.StatusBadRequest},
+		{"negative weight", http.MethodPost, "/admin/backends", `{"addr": "127.0.0.1:1", "weight": -1}`, http.StatusBadRequest},
+		{"negative max conns", http.MethodPost, "/admin/backends", `{"addr": "127.0.0.1:1", "max_conns": -1}`, http.StatusBadRequest},
+		{"invalid addr", http.MethodPost, "/admin/backends", `{"addr": "ftp://127.0.0.1:1"}`, http.StatusBadRequest},
+		{"duplicate", http.MethodPost, "/admin/backends", `{"addr": "` + upstream + `"}`, http.StatusConflict},
+		{"add to unknown pool", http.MethodPost, "/admin/backends", `{"pool": "missing", "addr": "127.0.0.1:1"}`, http.StatusNotFound},
+		{"list unknown pool", http.MethodGet, "/admin/backends?pool=missing", "", http.StatusNotFound},
+		{"get unknown backend", http.MethodGet, "/admin/backends?addr=127.0.0.1:1", "", http.StatusNotFound},
+		{"get in unknown pool", http.MethodGet, "/admin/backends/127.0.0.1:1?pool=missing", "", http.StatusNotFound},
+		{"delete unknown backend", http.MethodDelete, "/admin/backends/127.0.0.1:1", "", http.StatusNotFound},
+		{"delete without addr", http.MethodDelete, "/admin/backends", "", http.StatusMethodNotAllowed},
+		{"drain unknown backend", http.MethodPost, "/admin/backends/127.0.0.1:1/drain", "", http.StatusNotFound},
+		{"drain invalid timeout", http.MethodPost, "/admin/backends/drain?addr=" + url.QueryEscape(upstream) + "&timeout=soon", "", http.StatusBadRequest},
+		{"empty path addr", http.MethodGet, "/admin/backends/", "", http.StatusBadRequest},
+		{"pools method", http.MethodPost, "/admin/pools", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if rec := adminRequest(s, tt.method, tt.target, tt.body); rec.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, rec.Code, rec.Body)
+		}
+	}
+
+	p, _ := s.Pools.Get(pool.Default)
+	p.Defaults.Transport.Protocol = backend.ProtocolH2C
+	if rec := adminRequest(s, http.MethodPost, "/admin/backends", `{"addr": "https://127.0.0.1:1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an https backend to be rejected by an h2c pool, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_DrainWaitsForInFlightRequests(t *testing.T) {
+	var hits atomic.Int32
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		hits.Add(1)
+		started <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	s := newTestServer(t, nil, map[string]string{pool.Default: slow.URL})
+
+	// Hold a request in flight on the slow backend, then add a second one
+	inFlight := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		inFlight <- rec.Code
+	}()
+	<-started
+	addTestBackend(t, s, pool.Default, echoBackend(t))
+
+	drained := make(chan *httptest.ResponseRecorder)
+	go func() {
+		drained <- adminRequest(s, http.MethodPost, "/admin/backends/drain?addr="+url.QueryEscape(slow.URL)+"&wait=true&timeout=5s", "")
+	}()
+	p, _ := s.Pools.Get(pool.Default)
+	b, _ := p.GetBackend(slow.URL)
+	for !b.IsDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for range 5 {
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected new requests to be served by the other backend, got %d", rec.Code)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected the draining backend to receive no new requests, got %d", hits.Load())
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("Expected the drain to wait for the in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got %d", code)
+	}
+	var status drainStatus
+	json.NewDecoder((<-drained).Body).Decode(&status)
+	if !status.Draining || !status.Drained {
+		t.Errorf("Expected the backend to be drained, got %+v", status)
+	}
+}
+
+func TestAdmin_DrainWaitTimeout(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		started <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	s := newTestServer(t, nil, map[string]string{pool.Default: slow.URL})
+
+	go s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	start := time.Now()
+	rec := adminRequest(s, http.MethodPost, "/admin/backends/drain?addr="+url.QueryEscape(slow.URL)+"&wait=true&timeout=100ms", "")
+	elapsed := time.Since(start)
+	var status drainStatus
+	json.NewDecoder(rec.Body).Decode(&status)
+	if rec.Code != http.StatusOK || status.Drained || status.InFlight != 1 {
+		t.Errorf("Expected the drain to report a request in flight, got %d %+v", rec.Code, status)
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected the wait to be bounded by the timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/pool"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AddBackend registers a backend with a pool's balancer and health checker.
+func (s *Server) AddBackend(p *pool.Pool, b *backend.Backend) error {
+	if err := p.AddBackend(b); err != nil {
+		return err
+	}
+	log.Info().Str("pool", p.Name).Str("backend", b.Addr).Msg("Backend added")
+	return nil
+}
+
+// RemoveBackend stops routing new requests to a backend of the pool and
+// releases its resources in the background once in-flight requests have
+// finished.
+func (s *Server) RemoveBackend(p *pool.Pool, addr string) (*backend.Backend, error) {
+	b, err := p.RemoveBackend(addr)
+	if err != nil {
+		return nil, err
+	}
+	b.SetDraining(true)
+	go s.drainAndEvict(b)
+
+	log.Info().
+		Str("pool", p.Name).
+		Str("backend", addr).
+		Int64("in_flight", b.InFlight()).
+		Msg("Backend removed, draining in-flight requests")
+	return b, nil
+}
+
+// PoolBackends returns the registry through which discovery manages the
+// backends of a pool.
+func (s *Server) PoolBackends(p *pool.Pool) discovery.Registry {
+	return poolBackends{server: s, pool: p}
+}
+
+// poolBackends adapts a pool to discovery.Registry, so that discovered
+// backends are drained and their proxies evicted like any other.
+type poolBackends struct {
+	server *Server
+	pool   *pool.Pool
+}
+
+func (r poolBackends) AddBackend(b *backend.Backend) error {
+	return r.server.AddBackend(r.pool, b)
+}
+
+func (r poolBackends) RemoveBackend(addr string) (*backend.Backend, error) {
+	return r.server.RemoveBackend(r.pool, addr)
+}
+
+func (r poolBackends) GetBackend(addr string) (*backend.Backend, error) {
+	return r.pool.GetBackend(addr)
+}
+
+// drainAndEvict waits for in-flight requests to a removed backend to finish,
+// up to the configured drain timeout, and then drops its cached proxy.
+func (s *Server) drainAndEvict(b *backend.Backend) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DrainTimeout)
+	defer cancel()
+	if err := b.WaitDrained(ctx); err != nil {
+		log.Warn().
+			Str("backend", b.Addr).
+			Int64("in_flight", b.InFlight()).
+			Msg("Drain timeout elapsed, evicting backend with requests in flight")
+	}
+	s.evictProxy(b)
+	log.Info().Str("backend", b.Addr).Msg("Backend drained and evicted")
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+// bodyBuffer reads request bodies ahead of proxying so that they can be
+// sent again when a request is retried.
+type bodyBuffer struct {
+	memoryLimit int64 // Bodies up to this size are held in memory (0 disables buffering)
+	fileLimit   int64 // Larger bodies up to this size spill to a temp file (0 disables spilling)
+}
+
+// buffer reads the request body and makes it replayable through
+// r.GetBody. Bodies over the limits are streamed to the backend as usual and
+// stay non-replayable, so the request is not retried. The returned function
+// releases the buffer once the request is done.
+func (bb bodyBuffer) buffer(r *http.Request) (release func(), err error) {
+	release = func() {}
+	if bb.memoryLimit == 0 || r.Body == nil || r.Body == http.NoBody {
+		return release, nil
+	}
+
+	var mem bytes.Buffer
+	n, err := io.CopyN(&mem, r.Body, bb.memoryLimit+1)
+	if errors.Is(err, io.EOF) {
+		data := mem.Bytes()
+		setReplayableBody(r, int64(len(data)), func() io.Reader { return bytes.NewReader(data) })
+		return release, nil
+	}
+	if err != nil {
+		return release, err
+	}
+	if bb.fileLimit <= bb.memoryLimit {
+		r.Body = prefixedBody{io.MultiReader(&mem, r.Body), r.Body}
+		return release, nil
+	}
+
+	// The file is unlinked at once and lives only as long as its descriptor
+	f, err := os.CreateTemp("", "lb-request-body-*")
+	if err != nil {
+		return release, err
+	}
+	os.Remove(f.Name())
+	release = func() { f.Close() }
+
+	if _, err := mem.WriteTo(f); err != nil {
+		return release, err
+	}
+	m, err := io.CopyN(f, r.Body, bb.fileLimit-n+1)
+	size := n + m
+	if errors.Is(err, io.EOF) {
+		setReplayableBody(r, size, func() io.Reader { return io.NewSectionReader(f, 0, size) })
+		return release, nil
+	}
+	if err != nil {
+		return release, err
+	}
+	r.Body = prefixedBody{io.MultiReader(io.NewSectionReader(f, 0, size), r.Body), r.Body}
+	return release, nil
+}
+
+// setReplayableBody replaces the request body with one that can be read
+// again for every attempt through r.GetBody.
+func setReplayableBody(r *http.Request, size int64, open func() io.Reader) {
+	r.ContentLength = size
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(open()), nil
+	}
+	r.Body, _ = r.GetBody()
+}
+
+// replayable reports whether the request body can be sent more than once.
+func replayable(r *http.Request) bool {
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+// prefixedBody continues a partially buffered body with the rest of the
+// client's body.
+type prefixedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (b prefixedBody) Close() error {
+	return b.body.Close()
+}
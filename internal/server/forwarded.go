@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding headers. X-Forwarded-For is appended to by httputil.ReverseProxy.
+const (
+	headerForwarded      = "Forwarded"
+	headerXForwardedFor  = "X-Forwarded-For"
+	headerXForwardedHost = "X-Forwarded-Host"
+	headerXForwardedProt = "X-Forwarded-Proto"
+)
+
+// origin describes where a request originally came from.
+type origin struct {
+	peer      string   // Address of the connection's remote end
+	peerProto string   // Scheme of the connection from the peer
+	peerHost  string   // Host requested by the peer
+	clientIP  string   // Original client, resolved through trusted proxies
+	proto     string   // Scheme the client used
+	host      string   // Host the client requested
+	hops      []string // Addresses forwarded by trusted proxies, client first
+	elements  []string // Forwarded elements received from trusted proxies
+}
+
+// forwarding resolves request origins, honouring forwarding headers only
+// when they were set by trusted proxies.
+type forwarding struct {
+	trusted []netip.Prefix
+}
+
+// originKey is the context key of a request's origin.
+type originKey struct{}
+
+// requestOrigin returns the origin of a request resolved by the forwarding
+// middleware, or one derived from the connection alone.
+func requestOrigin(r *http.Request) *origin {
+	if o, ok := r.Context().Value(originKey{}).(*origin); ok {
+		return o
+	}
+	return (&forwarding{}).resolve(r)
+}
+
+// Middleware stores the resolved origin of each request in its context, so
+// that rate limiting, logging and proxying see the same client address.
+func (f *forwarding) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), originKey{}, f.resolve(r))))
+	})
+}
+
+func (f *forwarding) isTrusted(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range f.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve determines the origin of a request. Forwarding headers are only
+// read when the peer is a trusted proxy; the client is then the rightmost
+// forwarded address that is not itself a trusted proxy.
+func (f *forwarding) resolve(r *http.Request) *origin {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	o := &origin{
+		peer:      remoteIP(r),
+		peerProto: proto,
+		peerHost:  r.Host,
+		clientIP:  remoteIP(r),
+		proto:     proto,
+		host:      r.Host,
+	}
+	if !f.isTrusted(o.peer) {
+		return o
+	}
+
+	var protos, hosts []string
+	if values := r.Header.Values(headerForwarded); len(values) > 0 {
+		for _, element := range splitList(values) {
+			params := parseForwardedElement(element)
+			o.elements = append(o.elements, element)
+			o.hops = append(o.hops, params["for"])
+			protos = append(protos, params["proto"])
+			hosts = append(hosts, params["host"])
+		}
+	} else {
+		o.hops = splitList(r.Header.Values(headerXForwardedFor))
+		protos = splitList(r.Header.Values(headerXForwardedProt))
+		hosts = splitList(r.Header.Values(headerXForwardedHost))
+	}
+
+	client := len(o.hops) - 1
+	for client > 0 && f.isTrusted(o.hops[client]) {
+		client--
+	}
+	if client < 0 || o.hops[client] == "" {
+		return o
+	}
+	o.clientIP = o.hops[client]
+
+	// With Forwarded, the element naming the client was added by the first
+	// trusted proxy and describes the client's request. X-Forwarded-Proto
+	// and X-Forwarded-Host are set once, by the outermost proxy.
+	index := client
+	if r.Header.Get(headerForwarded) == "" {
+		index = 0
+	}
+	if index < len(protos) && protos[index] != "" {
+		o.proto = strings.ToLower(protos[index])
+	}
+	if index < len(hosts) && hosts[index] != "" {
+		o.host = hosts[index]
+	}
+	return o
+}
+
+// setHeaders sets the forwarding headers of a request proxied upstream.
+// Headers from untrusted peers are replaced rather than extended. The peer
+// itself is appended to X-Forwarded-For by the reverse proxy.
+func (o *origin) setHeaders(header http.Header) {
+	if len(o.hops) > 0 {
+		header.Set(headerXForwardedFor, strings.Join(o.hops, ", "))
+	} else {
+		header.Del(headerXForwardedFor)
+	}
+	header.Set(headerXForwardedProt, o.proto)
+	header.Set(headerXForwardedHost, o.host)
+
+	elements := o.elements
+	if elements == nil {
+		for _, hop := range o.hops {
+			elements = append(elements, "for="+forwardedNode(hop))
+		}
+	}
+	self := "for=" + forwardedNode(o.peer) + ";host=" + forwardedValue(o.peerHost) + ";proto=" + o.peerProto
+	header.Set(headerForwarded, strings.Join(append(elements, self), ", "))
+}
+
+// splitList splits comma-separated header values into trimmed items.
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseForwardedElement parses the parameters of one RFC 7239 element.
+// Node identifiers are reduced to the address without port.
+func parseForwardedElement(element string) map[string]string {
+	params := make(map[string]string)
+	for pair := range strings.SplitSeq(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(key)
+		value = strings.Trim(value, `"`)
+		if key == "for" {
+			value = nodeAddr(value)
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// nodeAddr strips the port and IPv6 brackets from a node identifier such
+// as "[2001:db8::1]:4711". Obfuscated identifiers are returned unchanged.
+func nodeAddr(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// forwardedNode formats an address as an RFC 7239 node identifier.
+func forwardedNode(addr string) string {
+	if strings.Contains(addr, ":") {
+		return `"[` + addr + `]"`
+	}
+	return forwardedValue(addr)
+}
+
+// forwardedValue quotes a parameter value unless it is a valid token.
+func forwardedValue(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+// isTokenChar reports whether c may appear in an HTTP token (RFC 9110).
+func isTokenChar(c rune) bool {
+	return c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// remoteIP returns the IP address of the connection a request came from.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
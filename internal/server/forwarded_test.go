@@ -0,0 +1,182 @@
+package server
+
+import (
+	"load-balancer/internal/client"
+	"load-balancer/internal/config"
+	"load-balancer/internal/pool"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestForwarding_Resolve(t *testing.T) {
+	f := &forwarding{trusted: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+	}}
+
+	tests := []struct {
+		name                  string
+		remoteAddr            string
+		headers               map[string]string
+		clientIP, proto, host string
+	}{
+		{
+			name:       "untrusted peer ignores headers",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https"},
+			clientIP:   "192.0.2.1", proto: "http", host: "lb.example.com",
+		},
+		{
+			name:       "trusted peer with X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"},
+			clientIP:   "198.51.100.7", proto: "https", host: "www.example.com",
+		},
+		{
+			name:       "spoofed entries left of an untrusted hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.2"},
+			clientIP:   "198.51.100.7", proto: "http", host: "lb.example.com",
+		},
+		{
+			name:       "only trusted hops",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			clientIP:   "10.0.0.3", proto: "http", host: "lb.example.com",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:1234",
+			clientIP:   "10.0.0.1", proto: "http", host: "lb.example.com",
+		},
+		{
+			name:       "Forwarded takes precedence",
+			remoteAddr: "[2001:db8::1]:1234",
+			headers: map[string]string{
+				"Forwarded":       `for=1.2.3.4;proto=http, for="[2001:db8:cafe::17]:4711";proto=https;host=www.example.com, for=10.0.0.2`,
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			clientIP: "2001:db8:cafe::17", proto: "https", host: "www.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://lb.example.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			o := f.resolve(req)
+			if o.clientIP != tt.clientIP || o.proto != tt.proto || o.host != tt.host {
+				t.Errorf("Expected %s %s %s, got %s %s %s", tt.clientIP, tt.proto, tt.host, o.clientIP, o.proto, o.host)
+			}
+		})
+	}
+}
+
+func TestServer_SetsForwardingHeaders(t *testing.T) {
+	headers := []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range headers {
+			w.Header().Set("X-Seen-"+name, r.Header.Get(name))
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	s := newTestServer(t, nil, map[string]string{pool.Default: upstream.URL})
+	f := &forwarding{trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	handler := f.Middleware(http.HandlerFunc(s.handleRequest))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       map[string]string
+	}{
+		{
+			name:       "untrusted",
+			remoteAddr: "192.0.2.1:1234",
+			want: map[string]string{
+				"X-Forwarded-For":   "192.0.2.1",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "lb.example.com",
+				"Forwarded":         "for=192.0.2.1;host=lb.example.com;proto=http",
+			},
+		},
+		{
+			name:       "trusted",
+			remoteAddr: "10.0.0.1:1234",
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.7, 10.0.0.1",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "www.example.com",
+				"Forwarded":         "for=198.51.100.7, for=10.0.0.1;host=lb.example.com;proto=http",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://lb.example.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "198.51.100.7")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "www.example.com")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			for name, value := range tt.want {
+				if got := rec.Header().Get("X-Seen-" + name); got != value {
+					t.Errorf("Expected %s %q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterManager_LimitsClientsBehindTrustedProxy(t *testing.T) {
+	store := client.NewInMemoryClientStore()
+	if err := store.Create(&client.Client{ID: "shared", Capacity: 100, RatePerSec: 1, APIKey: "key"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m := NewLimiterManager(store, &config.Config{RateLimitCapacity: 2, RateLimitRefillRate: 0.001, RateLimitPerIP: true})
+	f := &forwarding{trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	handler := f.Middleware(m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	serve := func(remoteAddr, forwardedFor, apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve("10.0.0.1:1234", "198.51.100.7", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected requests without an API key to be rejected, got %d", code)
+	}
+
+	// Both clients share an API key and a proxy but get their own IP buckets
+	for i := range 2 {
+		if code := serve("10.0.0.1:1234", "198.51.100.7", "key"); code != http.StatusOK {
+			t.Errorf("Request %d of the first client: expected 200, got %d", i, code)
+		}
+	}
+	if code := serve("10.0.0.1:1234", "198.51.100.7", "key"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the first client to be limited, got %d", code)
+	}
+	if code := serve("10.0.0.1:1234", "198.51.100.8", "key"); code != http.StatusOK {
+		t.Errorf("Expected the second client to keep its own bucket, got %d", code)
+	}
+
+	// An untrusted peer cannot pick another client's bucket
+	for range 2 {
+		serve("192.0.2.1:1234", "198.51.100.9", "key")
+	}
+	if code := serve("192.0.2.1:1234", "198.51.100.10", "key"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected forwarded addresses from untrusted peers to be ignored, got %d", code)
+	}
+}
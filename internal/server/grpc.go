@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes the load balancer answers with.
+const (
+	grpcUnknown           = 2
+	grpcDeadlineExceeded  = 4
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// isGRPC reports whether the request is a gRPC call. gRPC-Web calls are
+// excluded, as they carry their status in the body.
+func isGRPC(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.ProtoMajor == 2 && isGRPCMediaType(mediaType)
+}
+
+// isGRPCMediaType reports whether the media type is a gRPC payload.
+func isGRPCMediaType(mediaType string) bool {
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// httpError replies to the request with the error message and HTTP code.
+// gRPC clients do not read error bodies, so gRPC calls get the error as a
+// gRPC status instead.
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if !isGRPC(r) {
+		http.Error(w, message, code)
+		return
+	}
+	// A trailers-only response: the status goes in the headers and the
+	// stream ends without a body
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(grpcStatus(code)))
+	h.Set("Grpc-Message", grpcMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatus maps an HTTP error code to the gRPC status code reported for
+// it, following the gRPC mapping of HTTP statuses except that timeouts and
+// rate limiting get their more specific codes.
+func grpcStatus(code int) int {
+	switch code {
+	case http.StatusBadRequest, http.StatusInternalServerError:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	}
+	return grpcUnknown
+}
+
+// grpcMessage percent-encodes a status message for the grpc-message header.
+func grpcMessage(message string) string {
+	var b strings.Builder
+	for i := range len(message) {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/config"
+	"load-balancer/internal/pool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startHTTP2 serves the handler over TLS with HTTP/2, as gRPC clients
+// connect.
+func startHTTP2(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	lb := httptest.NewUnstartedServer(handler)
+	lb.EnableHTTP2 = true
+	lb.StartTLS()
+	t.Cleanup(lb.Close)
+	return lb
+}
+
+func grpcCall(t *testing.T, lb *httptest.Server) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, lb.URL+"/echo.Echo/Say", strings.NewReader("\x00\x00\x00\x00\x00"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := lb.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestServer_GRPCTrailers(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		io.Copy(w, r.Body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+
+	s := newTestServer(t, nil, map[string]string{pool.Default: echoBackend(t)})
+	p, _ := s.Pools.Get(pool.Default)
+	p.Defaults.Transport.Protocol = backend.ProtocolH2C
+	p.RemoveBackend(p.Balancer.GetBackends()[0].Addr)
+	addTestBackend(t, s, pool.Default, upstream.URL)
+
+	resp := grpcCall(t, startHTTP2(t, s.handleRequest))
+	body, _ := io.ReadAll(resp.Body)
+	if len(body) != 5 {
+		t.Errorf("Expected the message to be echoed, got %q", body)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" || resp.Trailer.Get("Grpc-Message") != "ok" {
+		t.Errorf("Expected trailers to be passed to the client, got %v", resp.Trailer)
+	}
+}
+
+func TestServer_GRPCErrors(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	s := newTestServer(t, nil, map[string]string{pool.Default: dead.URL})
+	lb := startHTTP2(t, s.handleRequest)
+
+	// Keep the backend in rotation after its initial health check fails
+	p, _ := s.Pools.Get(pool.Default)
+	b, _ := p.GetBackend(dead.URL)
+	deadline := time.Now().Add(5 * time.Second)
+	for b.State() != backend.StateUnhealthy && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.SetState(backend.StateHealthy)
+
+	resp := grpcCall(t, lb)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Grpc-Status") != "14" || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Errorf("Expected a gRPC UNAVAILABLE status, got %d with %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, err := lb.Client().Get(lb.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected plain HTTP clients to get 502, got %d", resp.StatusCode)
+	}
+}
+
+func TestGRPCMessage(t *testing.T) {
+	if msg := grpcMessage("100% bad\ngateway"); msg != "100%25 bad%0Agateway" {
+		t.Errorf("Expected percent-encoded message, got %q", msg)
+	}
+}
+
+func TestLimiterManager_RequestAPIKey(t *testing.T) {
+	m := NewLimiterManager(nil, &config.Config{GRPCAPIKeyMetadata: "authorization"})
+	tests := []struct {
+		name     string
+		grpc     bool
+		header   http.Header
+		expected string
+	}{
+		{"header", false, http.Header{"X-Api-Key": {"key"}}, "key"},
+		{"grpc metadata", true, http.Header{"X-Api-Key": {"key"}}, "key"},
+		{"grpc bearer token", true, http.Header{"Authorization": {"Bearer token"}}, "token"},
+		{"grpc plain token", true, http.Header{"Authorization": {"token"}}, "token"},
+		{"authorization without grpc", false, http.Header{"Authorization": {"Bearer token"}}, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header = tt.header
+		if tt.grpc {
+			req.ProtoMajor = 2
+			req.Header.Set("Content-Type", "application/grpc+proto")
+		}
+		if key := m.requestAPIKey(req); key != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, key)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errHedgeLost cancels an attempt after another attempt of the same hedged
+// request received a response.
+var errHedgeLost = errors.New("hedged request answered by another backend")
+
+// Latency samples kept per hedged route, and needed before a percentile is
+// used as the hedge delay.
+const (
+	latencySamples    = 256
+	minLatencySamples = 20
+)
+
+// hedgeGroup coordinates the concurrent attempts of a hedged request: the
+// first to receive a response answers the client and the others are
+// cancelled; a failure is only answered once no attempt is left running.
+type hedgeGroup struct {
+	mu      sync.Mutex
+	cancels map[*attempt]context.CancelCauseFunc
+	failed  int
+	done    bool // The client was answered, or is being answered
+}
+
+// add registers a new attempt, reporting false if the request is already
+// answered and the attempt must not be started.
+func (g *hedgeGroup) add(a *attempt, cancel context.CancelCauseFunc) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return false
+	}
+	if g.cancels == nil {
+		g.cancels = make(map[*attempt]context.CancelCauseFunc)
+	}
+	g.cancels[a] = cancel
+	return true
+}
+
+// claim reports whether the attempt's response answers the client, and
+// cancels the other attempts if so.
+func (g *hedgeGroup) claim(a *attempt) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return false
+	}
+	g.done = true
+	for other, cancel := range g.cancels {
+		if other != a {
+			cancel(errHedgeLost)
+		}
+	}
+	return true
+}
+
+// fail records a failed attempt and reports whether the failure must be
+// answered because no other attempt is left to answer the client.
+func (g *hedgeGroup) fail() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failed++
+	if g.done || g.failed < len(g.cancels) {
+		return false
+	}
+	g.done = true
+	return true
+}
+
+// serveHedged proxies the request to b and, if no response arrived within
+// the route's hedge delay, sends a duplicate to another backend of the pool.
+// The first response is passed to the client.
+func (s *Server) serveHedged(w http.ResponseWriter, r *http.Request, p *pool.Pool, filter balancer.Filter, b *backend.Backend, delay time.Duration) {
+	group := &hedgeGroup{}
+	var wg sync.WaitGroup
+
+	// The proxy aborts the handler with a panic when it fails to copy a
+	// response, such as when the client goes away. Attempts
+	// run on their own goroutines, so a panic is recovered there and raised
+	// again on the handler's once all attempts have finished, where the
+	// server can handle it instead of crashing the process.
+	var (
+		panicOnce sync.Once
+		panicked  any
+	)
+	defer func() {
+		if panicked != nil {
+			panic(panicked)
+		}
+	}()
+
+	start := func(b *backend.Backend) bool {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		a := &attempt{hedge: group}
+		if !group.add(a, cancel) {
+			cancel(nil)
+			return false
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel(nil)
+			defer func() {
+				if err := recover(); err != nil {
+					if err != http.ErrAbortHandler {
+						log.Error().
+							Str("request_id", getRequestInfo(r).requestID).
+							Interface("panic", err).
+							Bytes("stack", debug.Stack()).
+							Msg("Hedged attempt panicked")
+					}
+					panicOnce.Do(func() { panicked = err })
+				}
+			}()
+			s.serveAttempt(ctx, w, r, p, b, a)
+		}()
+		return true
+	}
+
+	start(b)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return
+	case <-timer.C:
+	}
+	hedge := s.nextBackend(p, func(candidate *backend.Backend) bool {
+		return candidate != b && (filter == nil || filter(candidate))
+	})
+	if hedge != nil {
+		if start(hedge) {
+			log.Debug().
+				Str("request_id", getRequestInfo(r).requestID).
+				Str("backend", b.Addr).
+				Str("hedge_backend", hedge.Addr).
+				Dur("delay", delay).
+				Msg("Sent hedged request")
+		} else {
+			hedge.Breaker.Cancel()
+			s.releaseBackend(hedge)
+		}
+	}
+	<-finished
+}
+
+// hedgeDelay returns how long to wait before hedging a request on the
+// route, or 0 if the request is not hedged. Upgrades are never hedged, as
+// only one backend may take over the connection.
+func (s *Server) hedgeDelay(route *router.Route, r *http.Request) time.Duration {
+	if route == nil || route.Hedge == nil || !idempotent(r.Method) || !replayable(r) || isUpgrade(r) {
+		return 0
+	}
+	if route.Hedge.Percentile > 0 {
+		if delay, ok := s.routeLatency(route).percentile(route.Hedge.Percentile); ok {
+			return delay
+		}
+	}
+	return route.Hedge.Delay
+}
+
+// routeLatency returns the latency window of a hedged route.
+func (s *Server) routeLatency(route *router.Route) *latencyWindow {
+	s.latenciesMu.Lock()
+	defer s.latenciesMu.Unlock()
+	window, ok := s.latencies[route]
+	if !ok {
+		window = &latencyWindow{}
+		s.latencies[route] = window
+	}
+	return window
+}
+
+// latencyWindow keeps the most recent response latencies of a route.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int // Position overwritten by the next sample once full
+}
+
+func (lw *latencyWindow) record(latency time.Duration) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.samples) < latencySamples {
+		lw.samples = append(lw.samples, latency)
+		return
+	}
+	lw.samples[lw.next] = latency
+	lw.next = (lw.next + 1) % latencySamples
+}
+
+// percentile returns the p-th percentile of the recorded latencies, or
+// false if too few have been recorded.
+func (lw *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	lw.mu.Lock()
+	sorted := slices.Clone(lw.samples)
+	lw.mu.Unlock()
+	if len(sorted) < minLatencySamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	return sorted[int(math.Ceil(p/100*float64(len(sorted))))-1], true
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_HedgesSlowRequests(t *testing.T) {
+	slow, slowHits := countingBackend(t, http.StatusOK, time.Second)
+	fast, fastHits := countingBackend(t, http.StatusOK, 0)
+	routes := []router.Route{{Pool: pool.Default, Hedge: &router.Hedge{Delay: 50 * time.Millisecond}}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: slow})
+	addTestBackend(t, s, pool.Default, fast)
+
+	start := time.Now()
+	for i := range 4 {
+		if code := serve(s, http.MethodGet, nil); code != http.StatusOK {
+			t.Errorf("Request %d: expected 200, got %d", i, code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("Expected slow requests to be answered by the hedge, took %v", elapsed)
+	}
+	if slowHits.Load() == 0 || fastHits.Load() != 4 {
+		t.Errorf("Expected every request answered by the fast backend, got %d slow and %d fast hits", slowHits.Load(), fastHits.Load())
+	}
+
+	// Non-idempotent requests are not hedged
+	start = time.Now()
+	for range 2 {
+		serve(s, http.MethodPost, nil)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected POST to wait for the slow backend, took %v", elapsed)
+	}
+}
+
+func TestServer_HedgeAnswersFailureOnce(t *testing.T) {
+	failing, _ := countingBackend(t, http.StatusBadGateway, 100*time.Millisecond)
+	routes := []router.Route{{Pool: pool.Default, Hedge: &router.Hedge{Delay: 10 * time.Millisecond}}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: failing})
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	addTestBackend(t, s, pool.Default, dead.URL)
+
+	// Whichever attempt fails first, the client gets exactly one answer
+	for i := range 4 {
+		if code := serve(s, http.MethodGet, nil); code != http.StatusBadGateway {
+			t.Errorf("Request %d: expected 502, got %d", i, code)
+		}
+	}
+}
+
+// panicWriter is a response writer that panics when the body is written.
+type panicWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w panicWriter) Write([]byte) (int, error) {
+	panic("write failed")
+}
+
+func TestServer_HedgeRaisesPanicOnHandlerGoroutine(t *testing.T) {
+	routes := []router.Route{{Pool: pool.Default, Hedge: &router.Hedge{Delay: time.Second}}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: echoBackend(t)})
+
+	// A panic in an attempt must reach the server's recovery on the
+	// handler goroutine instead of crashing the process
+	recovered := func() (err any) {
+		defer func() { err = recover() }()
+		s.handleRequest(panicWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/", nil))
+		return nil
+	}()
+	if recovered != "write failed" {
+		t.Errorf("Expected the panic to be raised on the handler goroutine, got %v", recovered)
+	}
+}
+
+func TestLatencyWindow_Percentile(t *testing.T) {
+	var lw latencyWindow
+	for i := range minLatencySamples - 1 {
+		lw.record(time.Duration(i+1) * time.Millisecond)
+	}
+	if _, ok := lw.percentile(50); ok {
+		t.Fatal("Expected no percentile before enough samples")
+	}
+
+	for i := range latencySamples {
+		lw.record(time.Duration(i%4+1) * 10 * time.Millisecond)
+	}
+	if p, ok := lw.percentile(50); !ok || p != 20*time.Millisecond {
+		t.Errorf("Expected a median of 20ms, got %v", p)
+	}
+	if p, _ := lw.percentile(95); p != 40*time.Millisecond {
+		t.Errorf("Expected p95 of 40ms once old samples are replaced, got %v", p)
+	}
+}
@@ -1,19 +1,30 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"load-balancer/internal/client"
 	"load-balancer/internal/config"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
+// ipLimiterSweep is the number of client IP limiters above which idle
+// ones are dropped when another is created.
+const ipLimiterSweep = 1024
+
 // LimiterManager manages rate limiters for all clients.
-// It creates and caches limiters based on API keys.
+// It creates and caches limiters based on API keys, and with
+// RATE_LIMIT_PER_IP also on the client IP resolved through trusted proxies.
 type LimiterManager struct {
 	clientStore   client.ClientStore
 	limiters      map[string]*ClientLimiter // Cached limiters by API key
+	ipLimiters    map[string]*ClientLimiter // Cached limiters by client IP (RATE_LIMIT_PER_IP)
+	ipSweepAt     int                       // Number of IP limiters that triggers the next sweep
 	mu            sync.Mutex
 	defaultConfig *config.Config
 }
@@ -22,9 +33,10 @@ type LimiterManager struct {
 // It uses a mutex for thread-safe access.
 type ClientLimiter struct {
 	mu         sync.Mutex
+	clientID   string    // ID of the client the limiter belongs to
 	capacity   int       // Maximum tokens (burst capacity)
 	tokens     int       // Current available tokens
-	ratePerSec int       // Tokens added per second
+	ratePerSec float64   // Tokens added per second
 	lastRefill time.Time // Last time tokens were refilled
 }
 
@@ -33,12 +45,15 @@ func NewLimiterManager(store client.ClientStore, cfg *config.Config) *LimiterMan
 	return &LimiterManager{
 		clientStore:   store,
 		limiters:      make(map[string]*ClientLimiter),
+		ipLimiters:    make(map[string]*ClientLimiter),
+		ipSweepAt:     ipLimiterSweep,
 		defaultConfig: cfg,
 	}
 }
 
-func newClientLimiter(capacity int, ratePerSec int) *ClientLimiter {
+func newClientLimiter(clientID string, capacity int, ratePerSec float64) *ClientLimiter {
 	return &ClientLimiter{
+		clientID:   clientID,
 		capacity:   capacity,
 		tokens:     capacity,
 		ratePerSec: ratePerSec,
@@ -64,33 +79,89 @@ func (m *LimiterManager) GetLimiter(apiKey string) (*ClientLimiter, error) {
 		return nil, err
 	}
 
-	limiter = newClientLimiter(c.Capacity, c.RatePerSec)
+	limiter = newClientLimiter(c.ID, c.Capacity, float64(c.RatePerSec))
 	m.limiters[apiKey] = limiter
 	return limiter, nil
 }
 
+// ipLimiter returns the limiter of requests from the client IP, which get
+// the default RATE_LIMIT_CAPACITY and RATE_LIMIT_REFILL_RATE. Limiters with a full bucket are dropped as the
+// map grows, as a new limiter behaves the same.
+func (m *LimiterManager) ipLimiter(ip string) *ClientLimiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limiter, ok := m.ipLimiters[ip]; ok {
+		return limiter
+	}
+	if len(m.ipLimiters) >= m.ipSweepAt {
+		for key, limiter := range m.ipLimiters {
+			if limiter.full() {
+				delete(m.ipLimiters, key)
+			}
+		}
+		m.ipSweepAt = max(ipLimiterSweep, 2*len(m.ipLimiters))
+	}
+
+	limiter := newClientLimiter("", max(1, int(m.defaultConfig.RateLimitCapacity)), m.defaultConfig.RateLimitRefillRate)
+	m.ipLimiters[ip] = limiter
+	return limiter
+}
+
+// clientIDKey is the context key of the ID of the client sending a request.
+type clientIDKey struct{}
+
+// requestClientID returns the ID of the API client sending the request, or
+// an empty string if the request was not authenticated.
+func requestClientID(r *http.Request) string {
+	id, _ := r.Context().Value(clientIDKey{}).(string)
+	return id
+}
+
+// requestAPIKey returns the API key of the request from the X-API-Key
+// header. gRPC calls may pass it in the GRPC_API_KEY_METADATA metadata
+// instead, where a bearer token is taken as the key.
+func (m *LimiterManager) requestAPIKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key != "" || !isGRPC(r) || m.defaultConfig.GRPCAPIKeyMetadata == "" {
+		return key
+	}
+	key = r.Header.Get(m.defaultConfig.GRPCAPIKeyMetadata)
+	if token, ok := strings.CutPrefix(key, "Bearer "); ok {
+		return token
+	}
+	return key
+}
+
 // Middleware returns an HTTP middleware that enforces rate limiting based on API keys.
+// With RATE_LIMIT_PER_IP each client IP is limited as well. The ID of the
+// authenticated client is stored in the request context.
 func (m *LimiterManager) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey := r.Header.Get("X-API-Key")
+		apiKey := m.requestAPIKey(r)
 		if apiKey == "" {
-			http.Error(w, "API key required", http.StatusUnauthorized)
+			httpError(w, r, "API key required", http.StatusUnauthorized)
 			return
 		}
 		limiter, err := m.GetLimiter(apiKey)
 		if err != nil {
 			if errors.Is(err, client.ErrClientNotFound) {
-				http.Error(w, "Invalid API key", http.StatusForbidden)
+				httpError(w, r, "Invalid API key", http.StatusForbidden)
 			} else {
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				httpError(w, r, "Internal server error", http.StatusInternalServerError)
 			}
 			return
 		}
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		clientIP := requestOrigin(r).clientIP
+		if !limiter.Allow() || m.defaultConfig.RateLimitPerIP && !m.ipLimiter(clientIP).Allow() {
+			log.Warn().
+				Str("client_id", limiter.clientID).
+				Str("client_ip", clientIP).
+				Msg("Rate limit exceeded")
+			httpError(w, r, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIDKey{}, limiter.clientID)))
 	})
 }
 
@@ -101,7 +172,7 @@ func (l *ClientLimiter) Allow() bool {
 	defer l.mu.Unlock()
 	now := time.Now()
 	elapsed := now.Sub(l.lastRefill).Seconds()
-	newTokens := int(elapsed * float64(l.ratePerSec))
+	newTokens := int(elapsed * l.ratePerSec)
 	if newTokens > 0 {
 		l.tokens = min(l.capacity, l.tokens+newTokens)
 		l.lastRefill = now
@@ -113,3 +184,10 @@ func (l *ClientLimiter) Allow() bool {
 	}
 	return false
 }
+
+// full reports whether the bucket would be full if refilled now.
+func (l *ClientLimiter) full() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tokens+int(time.Since(l.lastRefill).Seconds()*l.ratePerSec) >= l.capacity
+}
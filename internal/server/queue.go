@@ -0,0 +1,100 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// errQueueFull is returned when a request cannot wait for a backend
+// because the queue is at capacity.
+var errQueueFull = errors.New("request queue is full")
+
+// errQueueTimeout ends the wait of a request that spent QUEUE_TIMEOUT in
+// the queue.
+var errQueueTimeout = errors.New("timed out waiting for a backend")
+
+// requestQueue is a bounded FIFO of requests waiting for a backend
+// connection slot. A released slot is offered to waiters in arrival order:
+// a woken waiter that cannot use it (for example because its tag rule
+// selects other backends) passes it on to the next one.
+type requestQueue struct {
+	size int
+
+	mu      sync.Mutex
+	waiters *list.List // of chan struct{}, oldest first
+}
+
+func newRequestQueue(size int) *requestQueue {
+	return &requestQueue{size: size, waiters: list.New()}
+}
+
+// wait calls acquire whenever a slot may have been released, in FIFO order
+// with other waiters, until it returns true or the context is done, in
+// which case it returns the context's cause.
+func (q *requestQueue) wait(ctx context.Context, acquire func() bool) error {
+	ready := make(chan struct{}, 1)
+	q.mu.Lock()
+	if q.waiters.Len() >= q.size {
+		q.mu.Unlock()
+		return errQueueFull
+	}
+	elem := q.waiters.PushBack(ready)
+	q.mu.Unlock()
+	defer q.leave(elem)
+
+	// A slot may have been released while the caller was enqueueing
+	if acquire() {
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ready:
+		}
+		if acquire() {
+			return nil
+		}
+		q.mu.Lock()
+		q.wakeAfterLocked(elem)
+		q.mu.Unlock()
+	}
+}
+
+// leave removes a waiter, passing on a wake-up it received but did not use.
+func (q *requestQueue) leave(elem *list.Element) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-elem.Value.(chan struct{}):
+		q.wakeAfterLocked(elem)
+	default:
+	}
+	q.waiters.Remove(elem)
+}
+
+// notify wakes the oldest waiter after a slot has been released.
+func (q *requestQueue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.wakeFromLocked(q.waiters.Front())
+}
+
+// wakeAfterLocked wakes the first waiter queued after elem.
+func (q *requestQueue) wakeAfterLocked(elem *list.Element) {
+	q.wakeFromLocked(elem.Next())
+}
+
+// wakeFromLocked wakes the first waiter, starting at elem, that does not
+// already have a pending wake-up.
+func (q *requestQueue) wakeFromLocked(elem *list.Element) {
+	for ; elem != nil; elem = elem.Next() {
+		select {
+		case elem.Value.(chan struct{}) <- struct{}{}:
+			return
+		default:
+		}
+	}
+}
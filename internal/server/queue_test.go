@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until n requests are queued.
+func waitForWaiters(t *testing.T, q *requestQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		queued := q.waiters.Len()
+		q.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued requests, got %d", n, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRequestQueue_FIFO(t *testing.T) {
+	q := newRequestQueue(10)
+	var mu sync.Mutex
+	slots := 0
+	var order []int
+	acquire := func(id int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if slots == 0 {
+				return false
+			}
+			slots--
+			order = append(order, id)
+			return true
+		}
+	}
+
+	var wg sync.WaitGroup
+	for id := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := q.wait(context.Background(), acquire(id)); err != nil {
+				t.Errorf("Waiter %d: unexpected error %v", id, err)
+			}
+		}()
+		waitForWaiters(t, q, id+1)
+	}
+
+	// Release one slot at a time, so each is offered to the oldest waiter
+	for released := range 3 {
+		mu.Lock()
+		slots++
+		mu.Unlock()
+		q.notify()
+		waitForWaiters(t, q, 2-released)
+	}
+	wg.Wait()
+
+	for i, id := range order {
+		if id != i {
+			t.Fatalf("Expected FIFO order, got %v", order)
+		}
+	}
+}
+
+func TestRequestQueue_PassesSlotOn(t *testing.T) {
+	q := newRequestQueue(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first waiter can never use a slot, e.g. because of its tag rule
+	go q.wait(ctx, func() bool { return false })
+	waitForWaiters(t, q, 1)
+
+	done := make(chan error, 1)
+	calls := 0
+	go func() {
+		done <- q.wait(ctx, func() bool {
+			calls++
+			return calls > 1 // No slot yet while enqueueing
+		})
+	}()
+	waitForWaiters(t, q, 2)
+
+	q.notify()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a slot the first waiter cannot use to reach the second")
+	}
+}
+
+func TestRequestQueue_FullAndTimeout(t *testing.T) {
+	q := newRequestQueue(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.wait(ctx, func() bool { return false })
+	waitForWaiters(t, q, 1)
+
+	if err := q.wait(context.Background(), func() bool { return true }); !errors.Is(err, errQueueFull) {
+		t.Errorf("Expected errQueueFull, got %v", err)
+	}
+
+	q = newRequestQueue(1)
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer timeoutCancel()
+	if err := q.wait(timeoutCtx, func() bool { return false }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	waitForWaiters(t, q, 0)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"load-balancer/internal/router"
+	"net/http"
+)
+
+// requestIDHeader carries the ID identifying a request across services.
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo holds what is known about a proxied request, for use by the
+// reverse proxy's director and response hooks.
+type requestInfo struct {
+	route     *router.Route // Matched route, or nil
+	origin    *origin
+	requestID string
+	clientID  string
+}
+
+// requestInfoKey is the context key of a request's requestInfo.
+type requestInfoKey struct{}
+
+// withRequestInfo returns a copy of the request carrying info.
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+}
+
+// getRequestInfo returns the requestInfo of a request, or nil.
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// templateVars returns the values of header template variables for a
+// request proxied to the given backend.
+func (info *requestInfo) templateVars(backendAddr string) map[string]string {
+	return map[string]string{
+		router.VarClientIP:  info.origin.clientIP,
+		router.VarRequestID: info.requestID,
+		router.VarBackend:   backendAddr,
+		router.VarClientID:  info.clientID,
+	}
+}
+
+// newRequestID returns the client's request ID, or generates a new one.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
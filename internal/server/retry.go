@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/config"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// errPerTryTimeout cancels an attempt whose backend did not send response
+	// headers within the per-try timeout.
+	errPerTryTimeout = errors.New("per-try timeout exceeded")
+
+	// errRequestTimeout cancels a request that exceeded its total timeout.
+	errRequestTimeout = errors.New("request timeout exceeded")
+
+	// errRetryStatus rejects a response whose status code is retried.
+	errRetryStatus = errors.New("retryable response status")
+)
+
+// retryPolicy decides which failed attempts of a request are retried on
+// another backend.
+type retryPolicy struct {
+	attempts      int           // Retries allowed per request (0 disables retries)
+	statusCodes   []int         // Response status codes that are retried
+	perTryTimeout time.Duration // Time to response headers per attempt (0 disables)
+	safeHeader    string        // Header marking any request as safe to retry
+	budget        *retryBudget
+}
+
+func newRetryPolicy(cfg *config.Config) *retryPolicy {
+	return &retryPolicy{
+		attempts:      cfg.RetryAttempts,
+		statusCodes:   cfg.RetryStatusCodes,
+		perTryTimeout: cfg.RetryPerTryTimeout,
+		safeHeader:    cfg.RetrySafeHeader,
+		budget:        newRetryBudget(cfg.RetryBudgetRatio, cfg.RetryBudgetMinRetries, cfg.RetryBudgetWindow),
+	}
+}
+
+// retryable reports whether the request may be sent more than once: it
+// uses an idempotent method or is marked safe to retry by the client. Its
+// body must also be replayable for a retry to happen.
+func (p *retryPolicy) retryable(r *http.Request) bool {
+	if p.attempts == 0 {
+		return false
+	}
+	if idempotent(r.Method) {
+		return true
+	}
+	safe, _ := strconv.ParseBool(r.Header.Get(p.safeHeader))
+	return p.safeHeader != "" && safe
+}
+
+// idempotent reports whether requests with the method may be sent more
+// than once without changing their effect.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryStatus reports whether responses with the status code are retried.
+func (p *retryPolicy) retryStatus(code int) bool {
+	return slices.Contains(p.statusCodes, code)
+}
+
+// retryableError reports whether a proxy error may be retried: the backend
+// could not be reached, or did not answer in time. Errors caused by the
+// client going away are not retried.
+func retryableError(r *http.Request, err error) bool {
+	if errors.Is(context.Cause(r.Context()), errPerTryTimeout) {
+		return true
+	}
+	if r.Context().Err() != nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryBudget caps retries at a share of the requests seen in a window, so
+// that a failing pool is not hit by a storm of retries. A minimum number of
+// retries per window is always allowed, so quiet periods can still retry.
+type retryBudget struct {
+	ratio      float64
+	minRetries int
+	window     time.Duration
+
+	mu          sync.Mutex
+	requests    int
+	retries     int
+	windowStart time.Time
+
+	now func() time.Time // Clock, replaceable in tests
+}
+
+func newRetryBudget(ratio float64, minRetries int, window time.Duration) *retryBudget {
+	rb := &retryBudget{ratio: ratio, minRetries: minRetries, window: window, now: time.Now}
+	rb.windowStart = rb.now()
+	return rb
+}
+
+// recordRequest counts a request towards the budget.
+func (rb *retryBudget) recordRequest() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.advance()
+	rb.requests++
+}
+
+// withdraw takes one retry from the budget, reporting whether it was
+// available.
+func (rb *retryBudget) withdraw() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.advance()
+	if rb.retries >= max(rb.minRetries, int(rb.ratio*float64(rb.requests))) {
+		return false
+	}
+	rb.retries++
+	return true
+}
+
+// advance starts a new counting window once the current one has elapsed.
+// Must be called with mu held.
+func (rb *retryBudget) advance() {
+	if now := rb.now(); rb.window > 0 && now.Sub(rb.windowStart) >= rb.window {
+		rb.requests, rb.retries = 0, 0
+		rb.windowStart = now
+	}
+}
+
+// attempt tracks one try of a proxied request. It travels in the request
+// context so the reverse proxy's hooks can divert a failure into a retry
+// instead of answering the client.
+type attempt struct {
+	retry   func() *backend.Backend // Reserves a backend for a retry, or returns nil
+	hedge   *hedgeGroup             // Concurrent attempts of a hedged request, or nil
+	start   time.Time
+	w       http.ResponseWriter     // Client response writer of the attempt
+	cancel  context.CancelCauseFunc // Cancels the attempt's request to the backend
+	timer   *time.Timer             // Per-try timeout, stopped once headers arrive
+	stream  *time.Timer             // Maximum stream duration, once the response is streamed
+	next    *backend.Backend        // Backend reserved for the retry
+	failure string                  // Why the attempt failed without answering the client
+}
+
+// attemptKey is the context key of a request's current attempt.
+type attemptKey struct{}
+
+// getAttempt returns the attempt of a request, or nil.
+func getAttempt(r *http.Request) *attempt {
+	a, _ := r.Context().Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// tryRetry reserves a backend for retrying the failed attempt. It returns
+// false when the request must not be retried, in which case the failure is
+// passed to the client.
+func (a *attempt) tryRetry(failure string) bool {
+	if a.retry == nil || a.next != nil {
+		return false
+	}
+	a.next = a.retry()
+	a.failure = failure
+	return a.next != nil
+}
+
+// headersReceived stops the per-try timeout: once the backend answers, the
+// response is streamed to the client without a deadline.
+func (a *attempt) headersReceived() {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/pool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingBackend starts a backend that answers health checks with 200 and
+// other requests with the given status after the delay, counting them.
+func countingBackend(t *testing.T, status int, delay time.Duration) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		hits.Add(1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL, &hits
+}
+
+// newRetryTestServer creates a server with one pool of the given backends
+// and retries enabled.
+func newRetryTestServer(t *testing.T, perTryTimeout time.Duration, addrs ...string) *Server {
+	t.Helper()
+	s := newTestServer(t, nil, map[string]string{pool.Default: addrs[0]})
+	for _, addr := range addrs[1:] {
+		addTestBackend(t, s, pool.Default, addr)
+	}
+	s.retries = &retryPolicy{
+		attempts:      1,
+		statusCodes:   []int{http.StatusServiceUnavailable},
+		perTryTimeout: perTryTimeout,
+		safeHeader:    "X-Retry-Safe",
+		budget:        newRetryBudget(0.2, 100, time.Minute),
+	}
+	return s
+}
+
+func serve(s *Server, method string, header http.Header) int {
+	req := httptest.NewRequest(method, "/", nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, req)
+	return rec.Code
+}
+
+func TestServer_RetriesConnectErrors(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	ok, _ := countingBackend(t, http.StatusOK, 0)
+	s := newRetryTestServer(t, 0, dead.URL, ok)
+
+	// Wait for the initial health check to fail, then keep the backend in
+	// rotation so requests hit it
+	p, _ := s.Pools.Get(pool.Default)
+	b, _ := p.GetBackend(dead.URL)
+	deadline := time.Now().Add(5 * time.Second)
+	for b.State() != backend.StateUnhealthy && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.SetState(backend.StateHealthy)
+
+	for i := range 4 {
+		if code := serve(s, http.MethodGet, nil); code != http.StatusOK {
+			t.Errorf("Request %d: expected 200, got %d", i, code)
+		}
+	}
+}
+
+func TestServer_RetriesStatusCodes(t *testing.T) {
+	failing, failingHits := countingBackend(t, http.StatusServiceUnavailable, 0)
+	ok, okHits := countingBackend(t, http.StatusOK, 0)
+	s := newRetryTestServer(t, 0, failing, ok)
+
+	for i := range 4 {
+		if code := serve(s, http.MethodGet, nil); code != http.StatusOK {
+			t.Errorf("GET %d: expected 200, got %d", i, code)
+		}
+	}
+	if failingHits.Load() == 0 || okHits.Load() != 4 {
+		t.Errorf("Expected failed attempts to be retried, got %d failing and %d ok hits", failingHits.Load(), okHits.Load())
+	}
+
+	// POST is only retried when marked safe
+	codes := map[int]int{}
+	for range 4 {
+		codes[serve(s, http.MethodPost, nil)]++
+	}
+	if codes[http.StatusServiceUnavailable] == 0 {
+		t.Errorf("Expected POST not to be retried, got %v", codes)
+	}
+	for i := range 4 {
+		if code := serve(s, http.MethodPost, http.Header{"X-Retry-Safe": {"true"}}); code != http.StatusOK {
+			t.Errorf("Safe POST %d: expected 200, got %d", i, code)
+		}
+	}
+}
+
+func TestServer_RetriesPerTryTimeout(t *testing.T) {
+	slow, _ := countingBackend(t, http.StatusOK, time.Second)
+	fast, _ := countingBackend(t, http.StatusOK, 0)
+	s := newRetryTestServer(t, 100*time.Millisecond, slow, fast)
+
+	start := time.Now()
+	for i := range 4 {
+		if code := serve(s, http.MethodGet, nil); code != http.StatusOK {
+			t.Errorf("Request %d: expected 200, got %d", i, code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("Expected slow attempts to be abandoned, took %v", elapsed)
+	}
+
+	// Without another backend the timeout is reported to the client
+	s = newRetryTestServer(t, 100*time.Millisecond, slow)
+	if code := serve(s, http.MethodGet, nil); code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %d", code)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Now()
+	rb := newRetryBudget(0.5, 1, 10*time.Second)
+	rb.now = func() time.Time { return now }
+	rb.windowStart = now
+
+	for range 4 {
+		rb.recordRequest()
+	}
+	if !rb.withdraw() || !rb.withdraw() {
+		t.Fatal("Expected retries within the ratio to be allowed")
+	}
+	if rb.withdraw() {
+		t.Fatal("Expected the budget to be exhausted")
+	}
+
+	now = now.Add(10 * time.Second)
+	if !rb.withdraw() {
+		t.Fatal("Expected the minimum retries to be allowed in a new window")
+	}
+	if rb.withdraw() {
+		t.Fatal("Expected only the minimum retries without requests")
+	}
+}
+
+func TestServer_RetriesReplayBufferedBody(t *testing.T) {
+	failing, _ := countingBackend(t, http.StatusServiceUnavailable, 0)
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(echo.Close)
+	s := newRetryTestServer(t, 0, failing, echo.URL)
+	s.bodyBuffer = bodyBuffer{memoryLimit: 16, fileLimit: 64}
+
+	tests := []struct {
+		name    string
+		body    string
+		retried bool
+	}{
+		{"in memory", "small body", true},
+		{"spilled to file", strings.Repeat("file body ", 5), true},
+		{"over the limits", strings.Repeat("large body ", 10), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := map[int]int{}
+			for range 4 {
+				rec := httptest.NewRecorder()
+				s.handleRequest(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body)))
+				codes[rec.Code]++
+				if rec.Code == http.StatusOK && rec.Body.String() != tt.body {
+					t.Errorf("Expected body %q, got %q", tt.body, rec.Body.String())
+				}
+			}
+			if retried := codes[http.StatusServiceUnavailable] == 0; retried != tt.retried {
+				t.Errorf("Expected retried %v, got codes %v", tt.retried, codes)
+			}
+		})
+	}
+}
@@ -2,13 +2,18 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"load-balancer/internal/backend"
 	"load-balancer/internal/balancer"
 	"load-balancer/internal/client"
 	"load-balancer/internal/config"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -20,15 +25,25 @@ import (
 // It handles incoming requests, applies rate limiting, and proxies to backends.
 type Server struct {
 	Config        *config.Config
-	Balancer      *balancer.Balancer
+	Pools         *pool.Registry
+	Router        *router.Router
 	srv           *http.Server
-	proxies       map[string]*httputil.ReverseProxy // Cached reverse proxies per backend
+	admin         *http.Server                                // Admin API listener, nil if disabled
+	proxies       map[*backend.Backend]*httputil.ReverseProxy // Cached reverse proxies per backend
 	proxiesMu     sync.RWMutex
+	queue         *requestQueue // Requests waiting for a backend at its connection limit
+	retries       *retryPolicy
+	latencies     map[*router.Route]*latencyWindow // Recent latencies of hedged routes
+	latenciesMu   sync.Mutex
+	bodyBuffer    bodyBuffer      // Buffering of request bodies for retries
+	tunnels       *tunnelRegistry // Upgraded connections, closed on shutdown
+	closed        chan struct{}   // Closed on shutdown to stop background watchers
 	clientHandler *client.Handler
 }
 
-// NewServer creates a new load balancer server with the given configuration and balancer.
-func NewServer(cfg *config.Config, lb *balancer.Balancer) *Server {
+// NewServer creates a new load balancer server with the given configuration,
+// backend pools and routing table.
+func NewServer(cfg *config.Config, pools *pool.Registry, routes *router.Router) *Server {
 	clientStore := client.NewInMemoryClientStore()
 	clientHandler := client.NewHandler(clientStore)
 	clientMux := http.NewServeMux()
@@ -38,15 +53,31 @@ func NewServer(cfg *config.Config, lb *balancer.Balancer) *Server {
 
 	server := &Server{
 		Config:        cfg,
-		Balancer:      lb,
-		proxies:       make(map[string]*httputil.ReverseProxy),
+		Pools:         pools,
+		Router:        routes,
+		proxies:       make(map[*backend.Backend]*httputil.ReverseProxy),
+		queue:         newRequestQueue(cfg.QueueSize),
+		retries:       newRetryPolicy(cfg),
+		latencies:     make(map[*router.Route]*latencyWindow),
+		bodyBuffer:    bodyBuffer{memoryLimit: cfg.RequestBufferMemory, fileLimit: cfg.RequestBufferFileLimit},
+		tunnels:       newTunnelRegistry(cfg.TunnelIdleTimeout),
+		closed:        make(chan struct{}),
 		clientHandler: clientHandler,
 	}
 
-	proxyHandler := limiterManager.Middleware(http.HandlerFunc(server.handleRequest))
+	// Resolve the client address first so the rate limiter sees it too
+	forwarding := &forwarding{trusted: cfg.TrustedProxyPrefixes()}
+	proxyHandler := forwarding.Middleware(limiterManager.Middleware(http.HandlerFunc(server.handleRequest)))
+
+	// HTTP/2 is negotiated over TLS; cleartext HTTP/2 needs prior knowledge
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.ServerHTTP2)
+	protocols.SetUnencryptedHTTP2(cfg.ServerH2C)
 
 	server.srv = &http.Server{
-		Addr: cfg.ListenAddress,
+		Addr:      cfg.ListenAddress,
+		Protocols: protocols,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if strings.HasPrefix(r.URL.Path, "/clients") {
 				clientMux.ServeHTTP(w, r)
@@ -54,24 +85,73 @@ func NewServer(cfg *config.Config, lb *balancer.Balancer) *Server {
 			}
 			proxyHandler.ServeHTTP(w, r)
 		}),
-		ReadTimeout:       15 * time.Second,
-		WriteTimeout:      15 * time.Second,
-		IdleTimeout:       60 * time.Second,
-		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       cfg.ServerReadTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+	}
+
+	// The admin API can change where traffic goes, so it is only served on
+	// its own listener, which should not be reachable by clients
+	if cfg.AdminListenAddress != "" {
+		adminMux := http.NewServeMux()
+		server.registerAdminRoutes(adminMux)
+		server.admin = &http.Server{
+			Addr:              cfg.AdminListenAddress,
+			Handler:           adminMux,
+			ReadTimeout:       cfg.ServerReadTimeout,
+			IdleTimeout:       cfg.ServerIdleTimeout,
+			ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		}
 	}
 	return server
 }
 
-// Start starts the HTTP server and begins accepting requests.
+// StartAdmin starts the admin API listener, if one is configured.
+func (s *Server) StartAdmin() error {
+	if s.admin == nil {
+		return nil
+	}
+	log.Info().Msgf("Starting admin server on %s", s.Config.AdminListenAddress)
+	return s.admin.ListenAndServe()
+}
+
+// Start starts the HTTP server and begins accepting requests, over TLS if
+// certificates are configured. Certificates are reloaded when their files
+// change.
 func (s *Server) Start() error {
-	log.Info().Msgf("Starting server on %s", s.Config.ListenAddress)
-	return s.srv.ListenAndServe()
+	files := s.Config.Certificates()
+	if len(files) == 0 {
+		log.Info().Msgf("Starting server on %s", s.Config.ListenAddress)
+		return s.srv.ListenAndServe()
+	}
+
+	certs, err := newCertStore(files)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := certs.watch(s.closed); err != nil {
+			log.Error().Err(err).Msg("Certificate reloading stopped")
+		}
+	}()
+	s.srv.TLSConfig = newTLSConfig(s.Config, certs)
+	log.Info().Int("certificates", len(files)).Msgf("Starting HTTPS server on %s", s.Config.ListenAddress)
+	return s.srv.ListenAndServeTLS("", "")
 }
 
 // Shutdown gracefully shuts down the server within the given context timeout.
+// Upgraded connections are not tracked by the HTTP server; they are given
+// a short grace period to close and are then closed.
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Info().Msg("Shutting down server")
-	return s.srv.Shutdown(ctx)
+	close(s.closed)
+	err := s.srv.Shutdown(ctx)
+	if s.admin != nil {
+		err = cmp.Or(err, s.admin.Shutdown(ctx))
+	}
+	s.tunnels.shutdown(ctx)
+	return err
 }
 
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
@@ -80,31 +160,289 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		Stringer("url", r.URL).
 		Msg("Incoming request")
 
-	upstream := s.Balancer.GetNext()
-	if upstream == "" {
+	info := &requestInfo{
+		route:     s.Router.Match(r),
+		origin:    requestOrigin(r),
+		requestID: newRequestID(r),
+		clientID:  requestClientID(r),
+	}
+	r = withRequestInfo(r, info)
+
+	poolName := pool.Default
+	if info.route != nil {
+		log.Debug().Str("route", info.route.Label()).Str("pool", info.route.Pool).Msg("Matched route")
+		poolName = info.route.Pool
+	}
+	p, err := s.Pools.Get(poolName)
+	if err != nil {
+		log.Error().Err(err).Msg("No pool for request")
+		httpError(w, r, "No available backends", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The total timeout covers reading the body, queueing and all attempts.
+	// Upgraded connections would be cut off by it and use the tunnel idle
+	// timeout instead.
+	if timeout := requestTimeout(info.route, p); timeout > 0 && !isUpgrade(r) {
+		ctx, cancel := context.WithTimeoutCause(r.Context(), timeout, errRequestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if info.route != nil && info.route.Timeouts != nil && info.route.Timeouts.Write > 0 {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(info.route.Timeouts.Write)); err != nil {
+			log.Warn().Err(err).Msg("Failed to extend write deadline")
+		}
+	}
+
+	// Bodies of requests that may be retried or hedged are read before a backend is
+	// reserved, so slow uploads do not hold its connection slots
+	if s.retries.retryable(r) || info.route != nil && info.route.Hedge != nil && idempotent(r.Method) {
+		release, err := s.bodyBuffer.buffer(r)
+		defer release()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to read request body")
+			httpError(w, r, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter := s.requestFilter(r)
+	b, err := s.acquireBackend(r.Context(), p, filter)
+	switch {
+	case errors.Is(err, errQueueFull):
+		log.Warn().Msg("Request queue is full")
+		httpError(w, r, "Too many queued requests", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, errQueueTimeout):
+		log.Warn().Dur("timeout", s.Config.QueueTimeout).Msg("Timed out waiting for a backend")
+		httpError(w, r, "Timed out waiting for a backend", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, errRequestTimeout):
+		log.Warn().Msg("Request timeout exceeded while waiting for a backend")
+		httpError(w, r, "Gateway Timeout", http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		log.Debug().Err(err).Msg("Request cancelled while waiting for a backend")
+		return
+	case b == nil:
 		log.Error().Msg("No available backends")
-		http.Error(w, "No available backends", http.StatusServiceUnavailable)
+		httpError(w, r, "No available backends", http.StatusServiceUnavailable)
+		return
+	}
+	s.retries.budget.recordRequest()
+	s.serveWithRetries(w, r, p, filter, b)
+}
+
+// serveWithRetries proxies the request to b. When an attempt fails in a
+// way the retry policy allows to retry, the request is sent again to
+// another backend of the pool that has not been tried yet. Requests on
+// hedged routes are hedged instead.
+func (s *Server) serveWithRetries(w http.ResponseWriter, r *http.Request, p *pool.Pool, filter balancer.Filter, b *backend.Backend) {
+	info := getRequestInfo(r)
+	if delay := s.hedgeDelay(info.route, r); delay > 0 {
+		s.serveHedged(w, r, p, filter, b, delay)
 		return
 	}
 
-	proxy := s.getOrCreateProxy(upstream)
+	retryable := s.retries.retryable(r) && replayable(r)
+	tried := []*backend.Backend{b}
+	for retries := 0; ; retries++ {
+		var retry func() *backend.Backend
+		if retryable && retries < s.retries.attempts {
+			retry = func() *backend.Backend {
+				if !s.retries.budget.withdraw() {
+					log.Warn().Str("request_id", info.requestID).Msg("Retry budget exhausted")
+					return nil
+				}
+				return s.nextBackend(p, func(candidate *backend.Backend) bool {
+					return !slices.Contains(tried, candidate) && (filter == nil || filter(candidate))
+				})
+			}
+		}
+
+		a := &attempt{retry: retry}
+		s.serveAttempt(r.Context(), w, r, p, b, a)
+		if a.next == nil {
+			return
+		}
+		log.Warn().
+			Str("request_id", info.requestID).
+			Str("backend", b.Addr).
+			Str("failure", a.failure).
+			Str("next_backend", a.next.Addr).
+			Int("retry", retries+1).
+			Msg("Retrying request on another backend")
+		b = a.next
+		tried = append(tried, b)
+	}
+}
+
+// serveAttempt proxies one attempt of the request to b within ctx and
+// releases the backend afterwards. If the attempt's failure is retried,
+// nothing is written to the client and a.next holds the backend to retry on.
+func (s *Server) serveAttempt(ctx context.Context, w http.ResponseWriter, r *http.Request, p *pool.Pool, b *backend.Backend, a *attempt) {
+	defer s.releaseBackend(b)
+	info := getRequestInfo(r)
+
+	// The breaker slot reserved with the backend gets exactly one outcome,
+	// also when the proxy aborts the handler mid-response. Requests that
+	// were not sent or that the client abandoned say nothing about the
+	// backend.
+	proxied, success := false, false
+	defer func() {
+		if !proxied || clientCancelled(r) {
+			b.Breaker.Cancel()
+			return
+		}
+		b.Breaker.Record(success)
+	}()
+
+	proxy := s.getOrCreateProxy(b)
 	if proxy == nil {
-		log.Error().Str("backend", upstream).Msg("Failed to create proxy for backend")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		log.Error().Str("backend", b.Addr).Msg("Failed to create proxy for backend")
+		httpError(w, r, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	log.Info().
-		Str("backend", upstream).
+		Str("request_id", info.requestID).
+		Str("client_ip", info.origin.clientIP).
+		Str("pool", p.Name).
+		Str("backend", b.Addr).
 		Str("path", r.URL.Path).
 		Msg("Proxying request to backend")
 
-	proxy.ServeHTTP(w, r)
+	proxied = true
+	a.start = time.Now()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	a.cancel = cancel
+	if timeout := s.responseHeaderTimeout(info.route, b); timeout > 0 {
+		a.timer = time.AfterFunc(timeout, func() { cancel(errPerTryTimeout) })
+		defer a.timer.Stop()
+	}
+
+	// Every attempt sends its own copy of a buffered body
+	attemptReq := r.WithContext(context.WithValue(ctx, attemptKey{}, a))
+	if r.GetBody != nil {
+		attemptReq.Body, _ = r.GetBody()
+	}
+
+	if isUpgrade(r) {
+		w = &upgradeWriter{ResponseWriter: w, registry: s.tunnels, backend: b}
+	}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	a.w = rec
+	defer a.stopStream()
+	proxy.ServeHTTP(rec, attemptReq)
+	success = a.failure == "" && rec.status < http.StatusInternalServerError
+}
+
+// clientCancelled reports whether the client abandoned the request, as
+// opposed to the request running out of time.
+func clientCancelled(r *http.Request) bool {
+	return r.Context().Err() != nil && !errors.Is(context.Cause(r.Context()), errRequestTimeout)
+}
+
+// requestTimeout returns the total timeout of requests on the route to the
+// pool, or 0 if they have none.
+func requestTimeout(route *router.Route, p *pool.Pool) time.Duration {
+	if route != nil && route.Timeouts != nil && route.Timeouts.Request > 0 {
+		return route.Timeouts.Request
+	}
+	return p.RequestTimeout
+}
+
+// responseHeaderTimeout returns how long an attempt on the route waits for
+// response headers from the backend before it is abandoned. The route's
+// timeout replaces both the per-try timeout and the backend's default.
+func (s *Server) responseHeaderTimeout(route *router.Route, b *backend.Backend) time.Duration {
+	if route != nil && route.Timeouts != nil && route.Timeouts.ResponseHeader > 0 {
+		return route.Timeouts.ResponseHeader
+	}
+	timeout := b.ResponseHeaderTimeout()
+	if s.retries.perTryTimeout > 0 {
+		timeout = min(timeout, s.retries.perTryTimeout)
+	}
+	return timeout
 }
 
-func (s *Server) getOrCreateProxy(backend string) *httputil.ReverseProxy {
+// requestFilter returns the backend filter of the first tag rule matching
+// the request, or nil if no rule matches.
+func (s *Server) requestFilter(r *http.Request) balancer.Filter {
+	for _, rule := range s.Config.File.TagRules {
+		if rule.Header != "" {
+			value := r.Header.Get(rule.Header)
+			if value == "" || rule.Value != "" && value != rule.Value {
+				continue
+			}
+		}
+		return balancer.MatchTags(rule.Tags)
+	}
+	return nil
+}
+
+// acquireBackend reserves a connection slot on the next backend of the pool
+// accepted by the filter. When all such backends are at their connection
+// limit, the request waits in the queue for up to QUEUE_TIMEOUT. It returns
+// a nil backend if none is available regardless of limits.
+func (s *Server) acquireBackend(ctx context.Context, p *pool.Pool, filter balancer.Filter) (*backend.Backend, error) {
+	if b := s.nextBackend(p, filter); b != nil || !s.saturated(p, filter) {
+		return b, nil
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, s.Config.QueueTimeout, errQueueTimeout)
+	defer cancel()
+	var b *backend.Backend
+	err := s.queue.wait(ctx, func() bool {
+		b = s.nextBackend(p, filter)
+		return b != nil
+	})
+	return b, err
+}
+
+// releaseBackend frees a slot reserved by acquireBackend and hands it to
+// the oldest queued request.
+func (s *Server) releaseBackend(b *backend.Backend) {
+	b.DecInFlight()
+	s.queue.notify()
+}
+
+// saturated reports whether a backend of the pool accepted by the filter
+// could take the request but for its connection limit.
+func (s *Server) saturated(p *pool.Pool, filter balancer.Filter) bool {
+	for _, b := range p.Balancer.GetBackends() {
+		if b.AtCapacity() && b.IsAlive() && !b.IsDraining() && (filter == nil || filter(b)) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextBackend picks the next backend of the pool accepted by the filter and
+// reserves a connection slot on it, provided its circuit breaker admits the
+// request. The slot or a half-open probe slot may be taken concurrently
+// after the strategy selected the backend, so a few picks are attempted.
+func (s *Server) nextBackend(p *pool.Pool, filter balancer.Filter) *backend.Backend {
+	for range len(p.Balancer.GetBackends()) {
+		b := p.Balancer.Next(filter)
+		if b == nil {
+			return nil
+		}
+		if !b.TryAcquire() {
+			continue
+		}
+		if b.Breaker.Allow() {
+			return b
+		}
+		s.releaseBackend(b)
+	}
+	return nil
+}
+
+func (s *Server) getOrCreateProxy(b *backend.Backend) *httputil.ReverseProxy {
 	s.proxiesMu.RLock()
-	proxy, exists := s.proxies[backend]
+	proxy, exists := s.proxies[b]
 	s.proxiesMu.RUnlock()
 
 	if exists {
@@ -115,34 +453,123 @@ func (s *Server) getOrCreateProxy(backend string) *httputil.ReverseProxy {
 	defer s.proxiesMu.Unlock()
 
 	// Double-check in case another goroutine created it
-	if proxy, exists := s.proxies[backend]; exists {
+	if proxy, exists := s.proxies[b]; exists {
 		return proxy
 	}
 
-	targetURL, err := url.Parse("http://" + backend)
+	targetURL, err := b.URL()
+	if err != nil {
+		log.Error().Err(err).Str("backend", b.Addr).Msg("Failed to parse backend URL")
+		return nil
+	}
+	transport, err := b.Transport()
 	if err != nil {
-		log.Error().Err(err).Str("backend", backend).Msg("Failed to parse backend URL")
+		log.Error().Err(err).Str("backend", b.Addr).Msg("Failed to create backend transport")
 		return nil
 	}
 
 	proxy = httputil.NewSingleHostReverseProxy(targetURL)
-	proxy.Transport = &http.Transport{
-		ResponseHeaderTimeout: 10 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
+	proxy.Transport = transport
+
+	// Set the forwarding headers, then apply the matched route's path
+	// rewrite before joining the backend's base path, and its header rules
+	// in both directions
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		if info := getRequestInfo(req); info != nil {
+			info.origin.setHeaders(req.Header)
+			if info.route != nil {
+				info.route.RewriteURL(req.URL)
+				info.route.RequestHeaders.Apply(req.Header, info.templateVars(b.Addr))
+			}
+		}
+		director(req)
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if a := getAttempt(resp.Request); a != nil {
+			a.headersReceived()
+			if info := getRequestInfo(resp.Request); info != nil && info.route != nil && info.route.Hedge != nil {
+				s.routeLatency(info.route).record(time.Since(a.start))
+			}
+			if a.hedge != nil && !a.hedge.claim(a) {
+				return errHedgeLost
+			}
+			if s.retries.retryStatus(resp.StatusCode) && a.tryRetry(resp.Status) {
+				return errRetryStatus
+			}
+			if info := getRequestInfo(resp.Request); info != nil && streaming(resp, info.route) {
+				s.startStream(a, resp, b)
+			}
+		}
+		if info := getRequestInfo(resp.Request); info != nil && info.route != nil {
+			info.route.ResponseHeaders.Apply(resp.Header, info.templateVars(b.Addr))
+		}
+		return nil
 	}
 
-	// Add error handler
+	// Failures diverted into a retry are not answered; the request is sent
+	// to another backend instead
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if a := getAttempt(r); a != nil {
+			if errors.Is(err, errRetryStatus) || errors.Is(err, errHedgeLost) || errors.Is(context.Cause(r.Context()), errHedgeLost) {
+				return
+			}
+			if retryableError(r, err) && a.tryRetry(err.Error()) {
+				return
+			}
+			// Another attempt of a hedged request may still answer
+			if a.hedge != nil && !a.hedge.fail() {
+				a.failure = err.Error()
+				return
+			}
+		}
+		if cause := context.Cause(r.Context()); errors.Is(cause, errPerTryTimeout) || errors.Is(cause, errRequestTimeout) {
+			log.Error().Err(cause).Str("backend", b.Addr).Str("path", r.URL.Path).Msg("Backend did not respond in time")
+			httpError(w, r, "Gateway Timeout", http.StatusGatewayTimeout)
+			return
+		}
 		log.Error().
 			Err(err).
-			Str("backend", backend).
+			Str("backend", b.Addr).
 			Str("path", r.URL.Path).
 			Msg("Proxy error")
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		httpError(w, r, "Bad Gateway", http.StatusBadGateway)
 	}
 
-	s.proxies[backend] = proxy
-	log.Info().Str("backend", backend).Msg("Created new proxy for backend")
+	s.proxies[b] = proxy
+	log.Info().Str("backend", b.Addr).Msg("Created new proxy for backend")
 
 	return proxy
 }
+
+// evictProxy drops the cached reverse proxy for a backend and closes its
+// idle upstream connections.
+func (s *Server) evictProxy(b *backend.Backend) {
+	s.proxiesMu.Lock()
+	_, exists := s.proxies[b]
+	delete(s.proxies, b)
+	s.proxiesMu.Unlock()
+
+	b.CloseIdleConnections()
+	if exists {
+		log.Info().Str("backend", b.Addr).Msg("Evicted proxy for backend")
+	}
+}
+
+// statusRecorder captures the status code written by the reverse proxy so
+// the outcome of a request can be reported to the backend's circuit breaker.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController,
+// preserving flushing and hijacking support.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/config"
+	"load-balancer/internal/discovery"
+	"load-balancer/internal/health"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer creates a server whose pools each proxy to one backend.
+func newTestServer(t *testing.T, routes []router.Route, upstreams map[string]string) *Server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg := &config.Config{
+		ListenAddress: ":0",
+		QueueSize:     10,
+		QueueTimeout:  time.Second,
+		DrainTimeout:  time.Second,
+	}
+	r, err := router.New(routes)
+	if err != nil {
+		t.Fatalf("Invalid routes: %v", err)
+	}
+	pools := pool.NewRegistry()
+	s := NewServer(cfg, pools, r)
+
+	for name, addr := range upstreams {
+		p := pool.New(ctx, pool.Config{
+			Name:           name,
+			Strategy:       balancer.NewRoundRobinStrategy(nil),
+			Probe:          health.DefaultProbe(),
+			HealthInterval: time.Hour,
+		})
+		if err := pools.Add(p); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		addTestBackend(t, s, name, addr)
+	}
+	return s
+}
+
+// addTestBackend adds a healthy backend to a pool of the server.
+func addTestBackend(t *testing.T, s *Server, poolName, addr string) *backend.Backend {
+	t.Helper()
+	p, err := s.Pools.Get(poolName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b := p.NewBackend(discovery.Target{Addr: addr})
+	if err := s.AddBackend(p, b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b.SetState(backend.StateHealthy)
+	return b
+}
+
+// echoBackend starts a backend answering with the request URI it received.
+func echoBackend(t *testing.T) string {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RequestURI))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL
+}
+
+func TestServer_RewritesEncodedPaths(t *testing.T) {
+	routes := []router.Route{
+		{PathPrefix: "/api/users", StripPrefix: "/api/users", Pool: "users"},
+		{PathPrefix: "/files", Rewrite: &router.Rewrite{Regex: `^/files/(.*)$`, Replacement: "/storage/$1?source=lb"}, Pool: "users"},
+	}
+	s := newTestServer(t, routes, map[string]string{
+		pool.Default: echoBackend(t),
+		"users":      echoBackend(t) + "/base",
+	})
+
+	tests := []struct {
+		in, want string
+	}{
+		{"/api/users/42", "/base/42"},
+		{"/api/users/a%2Fb/c%20d?x=1", "/base/a%2Fb/c%20d?x=1"},
+		{"/api/%75sers/42", "/base/42"},
+		{"/files/dir%2Fname/%E2%9C%93.txt?v=2", "/base/storage/dir%2Fname/%E2%9C%93.txt?source=lb&v=2"},
+		{"/other/a%2Fb", "/other/a%2Fb"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, httptest.NewRequest(http.MethodGet, tt.in, nil))
+		body, _ := io.ReadAll(rec.Body)
+		if rec.Code != http.StatusOK || strings.TrimSpace(string(body)) != tt.want {
+			t.Errorf("%s: expected %s, got %d %s", tt.in, tt.want, rec.Code, body)
+		}
+	}
+}
+
+func TestServer_HeaderRules(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("X-Seen-Request-ID", r.Header.Get("X-Request-ID"))
+		w.Header().Set("X-Seen-Client", r.Header.Get("X-Client"))
+		w.Header().Set("X-Seen-Cookie", r.Header.Get("Cookie"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	routes := []router.Route{{
+		Pool: pool.Default,
+		RequestHeaders: &router.HeaderRules{
+			Set:    map[string]string{"X-Request-ID": "{request_id}", "X-Client": "{client_id}/{client_ip}"},
+			Remove: []string{"Cookie"},
+		},
+		ResponseHeaders: &router.HeaderRules{
+			Set:    map[string]string{"X-Backend": "{backend}", "X-Request-ID": "{request_id}"},
+			Remove: []string{"Server"},
+		},
+	}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: upstream.URL})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("Cookie", "session=secret")
+	req = req.WithContext(context.WithValue(req.Context(), clientIDKey{}, "user1"))
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, req)
+
+	want := map[string]string{
+		"X-Seen-Request-ID": "req-1",
+		"X-Seen-Client":     "user1/192.0.2.1",
+		"X-Seen-Cookie":     "",
+		"X-Backend":         upstream.URL,
+		"X-Request-ID":      "req-1",
+		"Server":            "",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("Expected %s %q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestServer_GeneratesRequestID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Request-ID")))
+	}))
+	t.Cleanup(upstream.Close)
+
+	routes := []router.Route{{
+		Pool:            pool.Default,
+		RequestHeaders:  &router.HeaderRules{Set: map[string]string{"X-Request-ID": "{request_id}"}},
+		ResponseHeaders: &router.HeaderRules{Set: map[string]string{"X-Request-ID": "{request_id}"}},
+	}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: upstream.URL})
+
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	id := rec.Header().Get("X-Request-ID")
+	if len(id) != 32 || rec.Body.String() != id {
+		t.Errorf("Expected the same generated request ID upstream and downstream, got %q and %q", rec.Body.String(), id)
+	}
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	slow, _ := countingBackend(t, http.StatusOK, time.Second)
+	routes := []router.Route{
+		{Path: "/request", Pool: pool.Default, Timeouts: &router.Timeouts{Request: 100 * time.Millisecond}},
+		{Path: "/header", Pool: pool.Default, Timeouts: &router.Timeouts{ResponseHeader: 100 * time.Millisecond}},
+		{Path: "/long", Pool: pool.Default, Timeouts: &router.Timeouts{Request: 2 * time.Second}},
+	}
+	s := newTestServer(t, routes, map[string]string{pool.Default: slow})
+	p, _ := s.Pools.Get(pool.Default)
+	p.RequestTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/request", http.StatusGatewayTimeout},
+		{"/header", http.StatusGatewayTimeout},
+		{"/pool", http.StatusGatewayTimeout},
+		{"/long", http.StatusOK},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d after %v", tt.path, tt.want, rec.Code, time.Since(start))
+		}
+	}
+}
+
+func TestServer_RouteResponseHeaderTimeoutOverridesPool(t *testing.T) {
+	slow, _ := countingBackend(t, http.StatusOK, 500*time.Millisecond)
+	routes := []router.Route{
+		{Path: "/slow", Pool: pool.Default, Timeouts: &router.Timeouts{
+			ResponseHeader: 5 * time.Second,
+			Request:        5 * time.Second,
+			Write:          5 * time.Second,
+		}},
+	}
+	s := newTestServer(t, routes, map[string]string{pool.Default: echoBackend(t)})
+	p, _ := s.Pools.Get(pool.Default)
+	p.Defaults.Transport.ResponseHeaderTimeout = 200 * time.Millisecond
+	p.RemoveBackend(p.Balancer.GetBackends()[0].Addr)
+	addTestBackend(t, s, pool.Default, slow)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/slow", http.StatusOK},
+		{"/pool", http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d after %v", tt.path, tt.want, rec.Code, time.Since(start))
+		}
+	}
+}
+
+func TestServer_QueueTimeouts(t *testing.T) {
+	slow, _ := countingBackend(t, http.StatusOK, 500*time.Millisecond)
+	routes := []router.Route{
+		{Path: "/request", Pool: pool.Default, Timeouts: &router.Timeouts{Request: 100 * time.Millisecond}},
+	}
+	s := newTestServer(t, routes, map[string]string{pool.Default: slow})
+	p, _ := s.Pools.Get(pool.Default)
+	b := p.Balancer.GetBackends()[0]
+	b.MaxConns = 1
+
+	tests := []struct {
+		path         string
+		queueTimeout time.Duration
+		want         int
+	}{
+		{"/queue", 100 * time.Millisecond, http.StatusServiceUnavailable},
+		{"/request", time.Second, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		s.Config.QueueTimeout = tt.queueTimeout
+
+		// Hold the backend's only slot so the request has to queue
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.handleRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/busy", nil))
+		}()
+		for b.InFlight() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		rec := httptest.NewRecorder()
+		s.handleRequest(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.want, rec.Code)
+		}
+		<-done
+	}
+}
+
+func TestServer_RouteWriteTimeout(t *testing.T) {
+	slow, _ := countingBackend(t, http.StatusOK, 300*time.Millisecond)
+	routes := []router.Route{{Path: "/poll", Pool: pool.Default, Timeouts: &router.Timeouts{Write: 2 * time.Second}}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: slow})
+
+	lb := httptest.NewUnstartedServer(http.HandlerFunc(s.handleRequest))
+	lb.Config.WriteTimeout = 100 * time.Millisecond
+	lb.Start()
+	t.Cleanup(lb.Close)
+
+	resp, err := http.Get(lb.URL + "/poll")
+	if err != nil {
+		t.Fatalf("Expected the route to extend the write timeout: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	if resp, err := http.Get(lb.URL + "/other"); err == nil {
+		resp.Body.Close()
+		t.Error("Expected the server write timeout to cut off other routes")
+	}
+}
+
+func TestServer_HTTP2EndToEnd(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+
+	s := newTestServer(t, nil, map[string]string{pool.Default: echoBackend(t)})
+	p, _ := s.Pools.Get(pool.Default)
+	p.Defaults.Transport.Protocol = backend.ProtocolH2C
+	if _, err := p.RemoveBackend(p.Balancer.GetBackends()[0].Addr); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	addTestBackend(t, s, pool.Default, upstream.URL)
+
+	lb := httptest.NewUnstartedServer(http.HandlerFunc(s.handleRequest))
+	lb.EnableHTTP2 = true
+	lb.StartTLS()
+	t.Cleanup(lb.Close)
+
+	resp, err := lb.Client().Get(lb.URL + "/proto")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Proto != "HTTP/2.0" || string(body) != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2 to the client and backend, got %s and %s", resp.Proto, body)
+	}
+}
+
+func TestServer_AbortedProbeKeepsBreakerRecoverable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slow" {
+			return
+		}
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(upstream.Close)
+
+	s := newTestServer(t, nil, map[string]string{pool.Default: upstream.URL})
+	p, _ := s.Pools.Get(pool.Default)
+	b := p.Balancer.GetBackends()[0]
+	b.Breaker = backend.NewCircuitBreaker(b.Addr, backend.BreakerSettings{
+		FailureRatio:   0.5,
+		MinRequests:    1,
+		Window:         time.Minute,
+		OpenDuration:   10 * time.Millisecond,
+		HalfOpenProbes: 1,
+	})
+	b.Breaker.Allow()
+	b.Breaker.Record(false)
+	time.Sleep(20 * time.Millisecond)
+	if state := b.Breaker.State(); state != backend.BreakerHalfOpen {
+		t.Fatalf("Expected the breaker to be half-open, got %s", state)
+	}
+
+	lb := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	t.Cleanup(lb.Close)
+
+	// The client abandons the probe while its response is being copied, which
+	// aborts the handler
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, lb.URL+"/slow", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Read(make([]byte, 7))
+	cancel()
+	resp.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.InFlight() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err = http.Get(lb.URL + "/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the next probe to reach the backend, got %d", resp.StatusCode)
+	}
+	if state := b.Breaker.State(); state != backend.BreakerClosed {
+		t.Errorf("Expected the breaker to close, got %s", state)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/router"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// streamEndGrace is added to the maximum stream duration when setting the
+// write deadline, leaving time to end the response cleanly.
+const streamEndGrace = 5 * time.Second
+
+// streaming reports whether the response is streamed to the client as the
+// backend produces it: server-sent events, gRPC calls, or any response on
+// a route marked as streaming. Other bodies of unknown length are flushed
+// immediately by the reverse proxy too, but keep the write timeout.
+func streaming(resp *http.Response, route *router.Route) bool {
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if route != nil && route.Streaming {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream" || isGRPCMediaType(mediaType)
+}
+
+// maxStreamDuration returns how long a response streamed on the route may
+// last, or 0 if it is not limited.
+func (s *Server) maxStreamDuration(route *router.Route) time.Duration {
+	if route != nil && route.Timeouts != nil && route.Timeouts.Stream > 0 {
+		return route.Timeouts.Stream
+	}
+	return s.Config.StreamMaxDuration
+}
+
+// startStream replaces the write timeout of a streamed response, which may
+// last far longer than ordinary responses, with the maximum stream duration,
+// and ends the stream once it is reached.
+func (s *Server) startStream(a *attempt, resp *http.Response, b *backend.Backend) {
+	var deadline time.Time
+	if d := s.maxStreamDuration(getRequestInfo(resp.Request).route); d > 0 {
+		deadline = time.Now().Add(d + streamEndGrace)
+		body := &streamBody{ReadCloser: resp.Body, resp: resp}
+		resp.Body = body
+		a.stream = time.AfterFunc(d, func() {
+			log.Info().Str("backend", b.Addr).Str("path", resp.Request.URL.Path).Dur("duration", d).Msg("Ending stream at maximum duration")
+			body.end()
+		})
+	}
+	if err := http.NewResponseController(a.w).SetWriteDeadline(deadline); err != nil {
+		log.Warn().Err(err).Msg("Failed to lift write deadline for stream")
+	}
+	log.Debug().Str("backend", b.Addr).Str("path", resp.Request.URL.Path).Msg("Streaming response")
+}
+
+// stopStream stops the maximum stream duration once the attempt is done.
+func (a *attempt) stopStream() {
+	if a.stream != nil {
+		a.stream.Stop()
+	}
+}
+
+// streamBody is the body of a streamed response that can be ended early.
+// Ending it closes the backend's body and reports a normal end of stream
+// to the proxy, so the client gets a complete response: the final chunk
+// of an HTTP/1.1 body, or the end of an HTTP/2 stream. gRPC clients
+// expect a status in the trailers, so they get DEADLINE_EXCEEDED.
+type streamBody struct {
+	io.ReadCloser
+	resp  *http.Response
+	ended atomic.Bool
+}
+
+// end stops the stream, interrupting a pending read.
+func (b *streamBody) end() {
+	b.ended.Store(true)
+	b.ReadCloser.Close()
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && b.ended.Load() {
+		mediaType, _, _ := mime.ParseMediaType(b.resp.Header.Get("Content-Type"))
+		if isGRPCMediaType(mediaType) {
+			if b.resp.Trailer == nil {
+				b.resp.Trailer = make(http.Header)
+			}
+			b.resp.Trailer.Set("Grpc-Status", strconv.Itoa(grpcDeadlineExceeded))
+			b.resp.Trailer.Set("Grpc-Message", grpcMessage("maximum stream duration reached"))
+		}
+		return n, io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/pool"
+	"load-balancer/internal/router"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventBackend starts a backend that sends count server-sent events at the
+// interval, or events until the client goes away if count is 0.
+func eventBackend(t *testing.T, count int, interval time.Duration) string {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; count == 0 || i < count; i++ {
+			if _, err := fmt.Fprintf(w, "data: %d\n\n", i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL
+}
+
+func TestServer_StreamsEvents(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: eventBackend(t, 5, 100*time.Millisecond)})
+
+	lb := httptest.NewUnstartedServer(http.HandlerFunc(s.handleRequest))
+	lb.Config.WriteTimeout = 150 * time.Millisecond
+	lb.Start()
+	t.Cleanup(lb.Close)
+
+	start := time.Now()
+	resp, err := http.Get(lb.URL + "/events")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Events arrive as they are sent, and past the server write timeout
+	br := bufio.NewReader(resp.Body)
+	var events []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			if len(events) == 0 && time.Since(start) > 80*time.Millisecond {
+				t.Errorf("Expected the first event to be flushed at once, took %v", time.Since(start))
+			}
+			events = append(events, strings.TrimSpace(data))
+		}
+	}
+	if len(events) != 5 {
+		t.Errorf("Expected 5 events, got %v", events)
+	}
+}
+
+func TestServer_StreamMaxDuration(t *testing.T) {
+	routes := []router.Route{{Path: "/events", Pool: pool.Default, Timeouts: &router.Timeouts{Stream: 200 * time.Millisecond}}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: eventBackend(t, 0, 20*time.Millisecond)})
+
+	lb := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	t.Cleanup(lb.Close)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	start := time.Now()
+	resp, err := client.Get(lb.URL + "/events")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The stream ends like a complete response, not a broken connection
+	br := bufio.NewReader(resp.Body)
+	for {
+		if _, err := br.ReadString('\n'); err != nil {
+			if err != io.EOF {
+				t.Errorf("Expected the stream to end cleanly, got %v", err)
+			}
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected the stream to end after 200ms, took %v", elapsed)
+	}
+}
+
+func TestServer_StreamMaxDurationGRPC(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		for {
+			if _, err := w.Write([]byte("\x00\x00\x00\x00\x00")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+
+	routes := []router.Route{{PathPrefix: "/", Pool: pool.Default, Timeouts: &router.Timeouts{Stream: 200 * time.Millisecond}}}
+	s := newTestServer(t, routes, map[string]string{pool.Default: echoBackend(t)})
+	p, _ := s.Pools.Get(pool.Default)
+	p.Defaults.Transport.Protocol = backend.ProtocolH2C
+	p.RemoveBackend(p.Balancer.GetBackends()[0].Addr)
+	addTestBackend(t, s, pool.Default, upstream.URL)
+
+	resp := grpcCall(t, startHTTP2(t, s.handleRequest))
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("Expected the stream to end cleanly, got %v", err)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "4" {
+		t.Errorf("Expected a DEADLINE_EXCEEDED status in the trailers, got %v", resp.Trailer)
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		length      int64
+		route       *router.Route
+		expected    bool
+	}{
+		{"server-sent events", "text/event-stream; charset=utf-8", -1, nil, true},
+		{"grpc", "application/grpc+proto", -1, nil, true},
+		{"chunked download", "application/octet-stream", -1, nil, false},
+		{"plain response", "text/plain", 5, nil, false},
+		{"route opt-in", "application/x-ndjson", -1, &router.Route{Streaming: true}, true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {tt.contentType}},
+			ContentLength: tt.length,
+			Request:       httptest.NewRequest(http.MethodGet, "/", nil),
+		}
+		if got := streaming(resp, tt.route); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"load-balancer/internal/config"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// certDebounce groups the file events of a certificate renewal, which
+// usually rewrites both the certificate and the key, into one reload.
+const certDebounce = 200 * time.Millisecond
+
+// certStore holds the certificates served to clients and reloads them when
+// their files change, so renewed certificates apply without a restart.
+type certStore struct {
+	files []config.Certificate
+	certs atomic.Pointer[[]tls.Certificate]
+}
+
+// newCertStore loads the certificates from their files.
+func newCertStore(files []config.Certificate) (*certStore, error) {
+	cs := &certStore{files: files}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// load reads all certificates and replaces the served ones. If any fails to
+// load, the served certificates are kept.
+func (cs *certStore) load() error {
+	certs := make([]tls.Certificate, 0, len(cs.files))
+	for _, f := range cs.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %w", f.CertFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	cs.certs.Store(&certs)
+	return nil
+}
+
+// getCertificate selects the first certificate that is valid for the server
+// name the client asks for (SNI) and that the client supports. The first
+// certificate is the default.
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *cs.certs.Load()
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// watch reloads the certificates whenever their files change, until stop
+// is closed. Reloads that fail are logged and the previous certificates are
+// kept. The parent directories are watched rather than the files, so that
+// atomic replacements and swapped symlinks (as in mounted secrets) are
+// detected.
+func (cs *certStore) watch(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	for _, f := range cs.files {
+		for _, path := range []string{f.CertFile, f.KeyFile} {
+			if err := watcher.Add(filepath.Dir(path)); err != nil {
+				return fmt.Errorf("failed to watch certificate directory: %w", err)
+			}
+		}
+	}
+
+	reload := time.NewTimer(certDebounce)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+				reload.Reset(certDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Msg("Certificate watcher error")
+		case <-reload.C:
+			if err := cs.load(); err != nil {
+				log.Error().Err(err).Msg("Failed to reload certificates, keeping previous ones")
+				continue
+			}
+			log.Info().Int("certificates", len(cs.files)).Msg("Certificates reloaded")
+		}
+	}
+}
+
+// newTLSConfig returns the TLS settings of the listener, serving the
+// certificates of the store.
+func newTLSConfig(cfg *config.Config, certs *certStore) *tls.Config {
+	return &tls.Config{
+		MinVersion:     cfg.TLSVersion(),
+		CipherSuites:   cfg.TLSCipherSuiteIDs(),
+		GetCertificate: certs.getCertificate,
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"load-balancer/internal/config"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for the host and its key to
+// name.pem and name-key.pem in dir.
+func writeCert(t *testing.T, dir, name, host string) config.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	cert := config.Certificate{
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	if err := os.WriteFile(cert.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(cert.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return cert
+}
+
+// handshake connects to a TLS server with the settings and returns the
+// name of the certificate it served.
+func handshake(serverConfig *tls.Config, serverName string, maxVersion uint16) (string, error) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		tls.Server(serverConn, serverConfig).Handshake()
+		serverConn.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true, MaxVersion: maxVersion})
+	if err := client.Handshake(); err != nil {
+		return "", err
+	}
+	return client.ConnectionState().PeerCertificates[0].DNSNames[0], nil
+}
+
+func TestCertStore_SelectsBySNI(t *testing.T) {
+	dir := t.TempDir()
+	certs, err := newCertStore([]config.Certificate{
+		writeCert(t, dir, "default", "default.example.com"),
+		writeCert(t, dir, "api", "api.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tlsConfig := newTLSConfig(&config.Config{TLSMinVersion: "1.2"}, certs)
+
+	tests := []struct {
+		serverName string
+		expected   string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"default.example.com", "default.example.com"},
+		{"other.example.com", "default.example.com"},
+		{"", "default.example.com"},
+	}
+	for _, tt := range tests {
+		name, err := handshake(tlsConfig, tt.serverName, 0)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.serverName, err)
+			continue
+		}
+		if name != tt.expected {
+			t.Errorf("%q: expected certificate for %s, got %s", tt.serverName, tt.expected, name)
+		}
+	}
+}
+
+func TestCertStore_MinVersion(t *testing.T) {
+	certs, err := newCertStore([]config.Certificate{writeCert(t, t.TempDir(), "default", "default.example.com")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tlsConfig := newTLSConfig(&config.Config{TLSMinVersion: "1.3"}, certs)
+
+	if _, err := handshake(tlsConfig, "default.example.com", tls.VersionTLS12); err == nil {
+		t.Error("Expected TLS 1.2 clients to be rejected")
+	}
+	if _, err := handshake(tlsConfig, "default.example.com", 0); err != nil {
+		t.Errorf("Expected TLS 1.3 clients to be accepted: %v", err)
+	}
+}
+
+func TestCertStore_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certs, err := newCertStore([]config.Certificate{writeCert(t, dir, "default", "old.example.com")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tlsConfig := newTLSConfig(&config.Config{TLSMinVersion: "1.2"}, certs)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go certs.watch(stop)
+	time.Sleep(50 * time.Millisecond)
+
+	// A broken key keeps the previous certificate
+	os.WriteFile(filepath.Join(dir, "default-key.pem"), []byte("not a key"), 0o600)
+	time.Sleep(2 * certDebounce)
+	if name, err := handshake(tlsConfig, "", 0); err != nil || name != "old.example.com" {
+		t.Fatalf("Expected the previous certificate after a failed reload, got %q (%v)", name, err)
+	}
+
+	writeCert(t, dir, "default", "new.example.com")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		name, err := handshake(tlsConfig, "", 0)
+		if err == nil && name == "new.example.com" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the renewed certificate to be served, got %q (%v)", name, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"load-balancer/internal/backend"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tunnelCloseGrace is how long shutdown lets upgraded connections close on
+// their own. Protocols such as WebSocket have no way to learn about the
+// shutdown, so most tunnels are closed once it passes.
+const tunnelCloseGrace = time.Second
+
+// isUpgrade reports whether the request asks to switch protocols, as
+// WebSocket handshakes do.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for token := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tunnelRegistry tracks the upgraded connections of the server so they can
+// be closed on shutdown, which http.Server does not do for hijacked
+// connections.
+type tunnelRegistry struct {
+	idleTimeout time.Duration // Closes tunnels without traffic in either direction (0 disables)
+
+	mu      sync.Mutex
+	tunnels map[*tunnel]struct{}
+	wg      sync.WaitGroup
+}
+
+func newTunnelRegistry(idleTimeout time.Duration) *tunnelRegistry {
+	return &tunnelRegistry{idleTimeout: idleTimeout, tunnels: make(map[*tunnel]struct{})}
+}
+
+// shutdown waits for open tunnels to close for tunnelCloseGrace or until
+// the context is done, whichever comes first, and then closes the remaining
+// ones.
+func (tr *tunnelRegistry) shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		tr.wg.Wait()
+		close(done)
+	}()
+
+	grace := time.NewTimer(tunnelCloseGrace)
+	defer grace.Stop()
+	select {
+	case <-done:
+		return
+	case <-grace.C:
+	case <-ctx.Done():
+	}
+
+	tr.mu.Lock()
+	open := make([]*tunnel, 0, len(tr.tunnels))
+	for t := range tr.tunnels {
+		open = append(open, t)
+	}
+	tr.mu.Unlock()
+
+	log.Info().Int("tunnels", len(open)).Msg("Closing upgraded connections")
+	for _, t := range open {
+		t.Close()
+	}
+	<-done
+}
+
+// upgradeWriter turns the client connection of an upgraded request into a
+// tracked tunnel when the reverse proxy hijacks it.
+type upgradeWriter struct {
+	http.ResponseWriter
+	registry *tunnelRegistry
+	backend  *backend.Backend
+}
+
+// Hijack takes over the client connection. The server's read and write
+// deadlines are cleared, as they are meant for requests and would cut off
+// long-lived tunnels; the tunnel's idle timeout applies instead.
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	t := &tunnel{Conn: conn, registry: w.registry, backend: w.backend}
+	t.touch()
+	timeout := w.registry.idleTimeout
+	if timeout > 0 {
+		// Started once assigned, as checkIdle resets it
+		t.idleTimer = time.AfterFunc(math.MaxInt64, t.checkIdle)
+	}
+	w.registry.mu.Lock()
+	w.registry.tunnels[t] = struct{}{}
+	w.registry.wg.Add(1)
+	w.registry.mu.Unlock()
+	w.backend.IncTunnels()
+	if t.idleTimer != nil {
+		t.idleTimer.Reset(timeout)
+	}
+	log.Debug().Str("backend", w.backend.Addr).Msg("Opened upgraded connection")
+	return t, rw, nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tunnel is the client side of an upgraded connection. All traffic passes
+// through it in one direction or the other, so it tracks idleness for the
+// whole tunnel.
+type tunnel struct {
+	net.Conn
+	registry   *tunnelRegistry
+	backend    *backend.Backend
+	lastActive atomic.Int64 // Unix nanoseconds of the last read or write
+	idleTimer  *time.Timer
+	closeOnce  sync.Once
+}
+
+func (t *tunnel) Read(p []byte) (int, error) {
+	n, err := t.Conn.Read(p)
+	if n > 0 {
+		t.touch()
+	}
+	return n, err
+}
+
+func (t *tunnel) Write(p []byte) (int, error) {
+	n, err := t.Conn.Write(p)
+	if n > 0 {
+		t.touch()
+	}
+	return n, err
+}
+
+func (t *tunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// checkIdle closes the tunnel if it has been idle for the idle timeout, or
+// checks again when it would be.
+func (t *tunnel) checkIdle() {
+	idle := time.Since(time.Unix(0, t.lastActive.Load()))
+	if remaining := t.registry.idleTimeout - idle; remaining > 0 {
+		t.idleTimer.Reset(remaining)
+		return
+	}
+	log.Info().Str("backend", t.backend.Addr).Dur("idle", idle).Msg("Closing idle upgraded connection")
+	t.Close()
+}
+
+// Close closes the client connection, which ends the reverse proxy's copy
+// loops and with them the backend connection.
+func (t *tunnel) Close() error {
+	err := t.Conn.Close()
+	t.closeOnce.Do(func() {
+		if t.idleTimer != nil {
+			t.idleTimer.Stop()
+		}
+		t.backend.DecTunnels()
+		t.registry.mu.Lock()
+		delete(t.registry.tunnels, t)
+		t.registry.mu.Unlock()
+		t.registry.wg.Done()
+		log.Debug().Str("backend", t.backend.Addr).Msg("Closed upgraded connection")
+	})
+	return err
+}
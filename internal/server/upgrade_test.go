@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"load-balancer/internal/backend"
+	"load-balancer/internal/pool"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upgradeBackend starts a backend that switches requests to an echo
+// protocol.
+func upgradeBackend(t *testing.T) string {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		io.Copy(conn, rw)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL
+}
+
+// dialTunnel opens an upgraded connection through the load balancer.
+func dialTunnel(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(addr, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	return conn, br
+}
+
+func echo(t *testing.T, conn net.Conn, br *bufio.Reader, msg string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	io.WriteString(conn, msg+"\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != msg+"\n" {
+		t.Fatalf("Expected echo of %q, got %q (%v)", msg, line, err)
+	}
+}
+
+func waitTunnels(t *testing.T, b *backend.Backend, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Tunnels() != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.Tunnels(); got != want {
+		t.Fatalf("Expected %d tunnels, got %d", want, got)
+	}
+}
+
+func TestServer_UpgradeOutlivesTimeouts(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: upgradeBackend(t)})
+	s.tunnels = newTunnelRegistry(300 * time.Millisecond)
+	p, _ := s.Pools.Get(pool.Default)
+	p.RequestTimeout = 100 * time.Millisecond
+	b := p.Balancer.GetBackends()[0]
+
+	lb := httptest.NewUnstartedServer(http.HandlerFunc(s.handleRequest))
+	lb.Config.ReadTimeout = 100 * time.Millisecond
+	lb.Config.WriteTimeout = 100 * time.Millisecond
+	lb.Start()
+	t.Cleanup(lb.Close)
+
+	conn, br := dialTunnel(t, lb.URL)
+	waitTunnels(t, b, 1)
+	if b.InFlight() != 1 {
+		t.Errorf("Expected the tunnel to hold a connection slot, got %d in flight", b.InFlight())
+	}
+
+	// Traffic keeps the tunnel open past the server and request timeouts
+	for range 4 {
+		time.Sleep(100 * time.Millisecond)
+		echo(t, conn, br, "ping")
+	}
+
+	// Without traffic it is closed after the idle timeout
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err == nil {
+		t.Fatal("Expected the idle tunnel to be closed")
+	}
+	waitTunnels(t, b, 0)
+}
+
+func TestServer_ShutdownClosesTunnels(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: upgradeBackend(t)})
+	p, _ := s.Pools.Get(pool.Default)
+	b := p.Balancer.GetBackends()[0]
+
+	lb := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	t.Cleanup(lb.Close)
+
+	conn, br := dialTunnel(t, lb.URL)
+	echo(t, conn, br, "ping")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err == nil {
+		t.Fatal("Expected shutdown to close the tunnel")
+	}
+	waitTunnels(t, b, 0)
+}
+
+func TestServer_ShutdownClosesTunnelsAfterGrace(t *testing.T) {
+	s := newTestServer(t, nil, map[string]string{pool.Default: upgradeBackend(t)})
+	p, _ := s.Pools.Get(pool.Default)
+	b := p.Balancer.GetBackends()[0]
+
+	lb := httptest.NewServer(http.HandlerFunc(s.handleRequest))
+	t.Cleanup(lb.Close)
+
+	conn, br := dialTunnel(t, lb.URL)
+	echo(t, conn, br, "ping")
+
+	// A long shutdown timeout does not keep idle tunnels open
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := time.Now()
+	s.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > tunnelCloseGrace+time.Second {
+		t.Errorf("Expected tunnels to be closed after %v, shutdown took %v", tunnelCloseGrace, elapsed)
+	}
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err == nil {
+		t.Fatal("Expected shutdown to close the tunnel")
+	}
+	waitTunnels(t, b, 0)
+}